@@ -0,0 +1,283 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+)
+
+// CipherContext holds the AES cipher and CMAC subkeys for a single key, so
+// that it can be re-used for calculating the MIC and encrypting the
+// FRMPayload of multiple frames without allocations.
+//
+// A CipherContext is software-only: it holds the key in memory and
+// implements AES-CMAC itself, as the CMAC of the SoftwareKeyProvider
+// allocates on every call. It is not built on a KeyProvider, thus keys that
+// are kept within a secure module must use the ...WithProvider functions.
+// A *CipherContext can be used as key handle of the SoftwareKeyProvider.
+// A CipherContext must not be used concurrently.
+type CipherContext struct {
+	key    AES128Key
+	block  cipher.Block
+	k1, k2 [16]byte
+
+	// scratch space, these are part of the context so that they do not
+	// escape to the heap on every call
+	x    [16]byte
+	a    [16]byte
+	s    [16]byte
+	buf  []byte
+	opts micOptions
+}
+
+// NewCipherContext returns a new CipherContext for the given key.
+func NewCipherContext(key AES128Key) (*CipherContext, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	if block.BlockSize() != 16 {
+		return nil, errors.New("lorawan: block-size of 16 bytes is expected")
+	}
+
+	c := CipherContext{
+		key:   key,
+		block: block,
+		// B0 | MHDR | max MACPayload size
+		buf: make([]byte, 0, 16+1+250),
+	}
+
+	// generate the CMAC subkeys (RFC 4493, section 2.3)
+	block.Encrypt(c.k1[:], c.k1[:])
+	cmacShiftLeft(c.k1[:], c.k1[:])
+	cmacShiftLeft(c.k2[:], c.k1[:])
+
+	return &c, nil
+}
+
+// cmacShiftLeft sets dst to src << 1, xor-ed with Rb when the most
+// significant bit of src is set.
+func cmacShiftLeft(dst, src []byte) {
+	msb := src[0] >> 7
+	for i := 0; i < 15; i++ {
+		dst[i] = src[i]<<1 | src[i+1]>>7
+	}
+	dst[15] = src[15] << 1
+	if msb == 1 {
+		dst[15] ^= 0x87
+	}
+}
+
+// cmac returns the AES-CMAC of data (RFC 4493, section 2.4).
+func (c *CipherContext) cmac(data []byte) [16]byte {
+	c.x = [16]byte{}
+
+	n := (len(data) + 15) / 16
+	if n == 0 {
+		n = 1
+	}
+
+	for i := 0; i < n-1; i++ {
+		for j := range c.x {
+			c.x[j] ^= data[i*16+j]
+		}
+		c.block.Encrypt(c.x[:], c.x[:])
+	}
+
+	last := data[(n-1)*16:]
+	if len(last) == 16 {
+		for j := range c.x {
+			c.x[j] ^= last[j] ^ c.k1[j]
+		}
+	} else {
+		for j, v := range last {
+			c.x[j] ^= v
+		}
+		c.x[len(last)] ^= 0x80
+		for j := range c.x {
+			c.x[j] ^= c.k2[j]
+		}
+	}
+	c.block.Encrypt(c.x[:], c.x[:])
+
+	return c.x
+}
+
+// calculateMIC calculates the data-frame MIC using the context. See
+// PHYPayload.calculateMIC for the meaning of the key and options.
+func (c *CipherContext) calculateMIC(p *PHYPayload, opts []MICOption) ([4]byte, error) {
+	var mic [4]byte
+
+	macPayload, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return mic, errors.New("lorawan: MACPayload should be of type *MACPayload")
+	}
+
+	p.setMICOptions(&c.opts, opts)
+
+	// the first 16 bytes are reserved for the B0 / B1 block
+	var err error
+	c.buf, err = p.appendMICPayload(c.buf[:16])
+	if err != nil {
+		return mic, err
+	}
+
+	p.setMICBlocks(macPayload, c.opts, len(c.buf)-16, c.buf[0:16], c.a[:])
+
+	if c.opts.macVersion < LoRaWAN1_1 || !p.isUplink() {
+		cmac := c.cmac(c.buf)
+		copy(mic[:], cmac[0:4])
+		return mic, nil
+	}
+
+	if c.opts.sNwkSIntKey == nil {
+		return mic, errors.New("lorawan: SNwkSIntKey must be set for the LoRaWAN 1.1 uplink MIC")
+	}
+	sCtx, ok := c.opts.sNwkSIntKey.(*CipherContext)
+	if !ok {
+		// this allocates, use a *CipherContext as SNwkSIntKey handle to
+		// avoid this
+		key, err := SoftwareKeyProvider{}.key(c.opts.sNwkSIntKey)
+		if err != nil {
+			return mic, err
+		}
+		if sCtx, err = NewCipherContext(key); err != nil {
+			return mic, err
+		}
+	}
+
+	cmacF := c.cmac(c.buf)
+	copy(c.buf[0:16], c.a[:])
+	cmacS := sCtx.cmac(c.buf)
+
+	copy(mic[0:2], cmacS[0:2])
+	copy(mic[2:4], cmacF[0:2])
+	return mic, nil
+}
+
+// encryptFRMPayload encrypts (or decrypts) data in place.
+func (c *CipherContext) encryptFRMPayload(uplink bool, devAddr DevAddr, fCnt uint32, data []byte) {
+	c.a = [16]byte{0x01}
+	if !uplink {
+		c.a[5] = 0x01
+	}
+
+	// little endian
+	c.a[6], c.a[7], c.a[8], c.a[9] = devAddr[3], devAddr[2], devAddr[1], devAddr[0]
+	binary.LittleEndian.PutUint32(c.a[10:14], fCnt)
+
+	for i := 0; i*16 < len(data); i++ {
+		c.a[15] = byte(i + 1)
+		c.block.Encrypt(c.s[:], c.a[:])
+
+		block := data[i*16:]
+		if len(block) > 16 {
+			block = block[:16]
+		}
+		for j := range block {
+			block[j] ^= c.s[j]
+		}
+	}
+}
+
+// SetMICWithContext calculates and sets the MIC field using the given
+// CipherContext. For data frames this does not allocate.
+func (p *PHYPayload) SetMICWithContext(ctx *CipherContext, opts ...MICOption) error {
+	if _, ok := p.MACPayload.(*MACPayload); !ok || p.MHDR.MType == Proprietary {
+		return p.SetMIC(ctx.key, opts...)
+	}
+
+	// the MIC is set for the (possibly modified) fields
+	p.raw = nil
+
+	mic, err := ctx.calculateMIC(p, opts)
+	if err != nil {
+		return err
+	}
+	p.MIC = mic
+	return nil
+}
+
+// ValidateMICWithContext returns if the MIC is valid, using the given
+// CipherContext. For data frames this does not allocate.
+func (p *PHYPayload) ValidateMICWithContext(ctx *CipherContext, opts ...MICOption) (bool, error) {
+	if _, ok := p.MACPayload.(*MACPayload); !ok || p.MHDR.MType == Proprietary {
+		return p.ValidateMIC(ctx.key, opts...)
+	}
+
+	mic, err := ctx.calculateMIC(p, opts)
+	if err != nil {
+		return false, err
+	}
+	return mic == p.MIC, nil
+}
+
+// ValidateMICFCntWithContext is like ValidateMICFCnt, but validates the MIC
+// using the given CipherContext.
+func (p *PHYPayload) ValidateMICFCntWithContext(ctx *CipherContext, expectedFCnt, maxFCntGap uint32, opts ...MICOption) (uint32, bool, error) {
+	return p.validateMICFCnt(func() (bool, error) {
+		return p.ValidateMICWithContext(ctx, opts...)
+	}, expectedFCnt, maxFCntGap)
+}
+
+// EncryptFRMPayloadWithContext encrypts the FRMPayload using the given
+// CipherContext. When the FRMPayload consists of a single DataPayload, it
+// is encrypted in place and this does not allocate.
+func (p *PHYPayload) EncryptFRMPayloadWithContext(ctx *CipherContext) error {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+
+	// nothing to encrypt
+	if len(macPL.FRMPayload) == 0 {
+		return nil
+	}
+
+	if len(macPL.FRMPayload) == 1 {
+		if dp, ok := macPL.FRMPayload[0].(*DataPayload); ok {
+			ctx.encryptFRMPayload(p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, dp.Bytes)
+			return nil
+		}
+	}
+
+	data, err := macPL.marshalPayload()
+	if err != nil {
+		return err
+	}
+	ctx.encryptFRMPayload(p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, data)
+
+	// store the encrypted data in a DataPayload
+	macPL.FRMPayload = []Payload{&DataPayload{Bytes: data}}
+
+	return nil
+}
+
+// DecryptFRMPayloadWithContext decrypts the FRMPayload using the given
+// CipherContext. The FRMPayload is decrypted in place. Unless the
+// FRMPayload contains MAC commands, this does not allocate.
+func (p *PHYPayload) DecryptFRMPayloadWithContext(ctx *CipherContext) error {
+	if err := p.EncryptFRMPayloadWithContext(ctx); err != nil {
+		return err
+	}
+
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+
+	// the FRMPayload contains MAC commands, which we need to unmarshal
+	if macPL.FPort != nil && *macPL.FPort == 0 && len(macPL.FRMPayload) != 0 {
+		dp, ok := macPL.FRMPayload[0].(*DataPayload)
+		if !ok {
+			return errors.New("lorawan: a DataPayload was expected")
+		}
+
+		if err := macPL.unmarshalPayload(p.getCodec(), p.isUplink(), dp.Bytes); err != nil {
+			return wrapDecodeError("PHYPayload.MACPayload", 1+7+int(macPL.FHDR.FCtrl.fOptsLen)+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package lorawan
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestDataUp() PHYPayload {
+	fPort := uint8(10)
+	return PHYPayload{
+		MHDR: MHDR{
+			MType: ConfirmedDataUp,
+			Major: LoRaWANR1,
+		},
+		MACPayload: &MACPayload{
+			FHDR: FHDR{
+				DevAddr: DevAddr{1, 2, 3, 4},
+				FCtrl: FCtrl{
+					ADR: true,
+					ACK: true,
+				},
+				FCnt:     12345,
+				RawFOpts: []byte{1, 2, 3},
+			},
+			FPort:      &fPort,
+			FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}}},
+		},
+	}
+}
+
+func TestCipherContextCMAC(t *testing.T) {
+	Convey("Given a CipherContext with key 2b7e151628aed2a6abf7158809cf4f3c (RFC 4493)", t, func() {
+		var key AES128Key
+		So(key.UnmarshalText([]byte("2b7e151628aed2a6abf7158809cf4f3c")), ShouldBeNil)
+		ctx, err := NewCipherContext(key)
+		So(err, ShouldBeNil)
+
+		msg, err := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+		So(err, ShouldBeNil)
+
+		tests := []struct {
+			Len  int
+			CMAC string
+		}{
+			{0, "bb1d6929e95937287fa37d129b756746"},
+			{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+			{40, "dfa66747de9ae63030ca32611497c827"},
+			{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+		}
+
+		for _, test := range tests {
+			Convey(fmt.Sprintf("Then the CMAC of the first %d bytes equals %s", test.Len, test.CMAC), func() {
+				cmac := ctx.cmac(msg[:test.Len])
+				So(hex.EncodeToString(cmac[:]), ShouldEqual, test.CMAC)
+			})
+		}
+	})
+}
+
+func TestCipherContext(t *testing.T) {
+	Convey("Given a FNwkSIntKey, SNwkSIntKey and AppSKey", t, func() {
+		fNwkSIntKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		sNwkSIntKey := AES128Key{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+		appSKey := AES128Key{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+
+		fCtx, err := NewCipherContext(fNwkSIntKey)
+		So(err, ShouldBeNil)
+		sCtx, err := NewCipherContext(sNwkSIntKey)
+		So(err, ShouldBeNil)
+		appCtx, err := NewCipherContext(appSKey)
+		So(err, ShouldBeNil)
+
+		Convey("Then the LoRaWAN 1.0 MIC equals the MIC calculated by SetMIC", func() {
+			phyA := newTestDataUp()
+			phyB := newTestDataUp()
+			So(phyA.SetMIC(fNwkSIntKey), ShouldBeNil)
+			So(phyB.SetMICWithContext(fCtx), ShouldBeNil)
+			So(phyB.MIC, ShouldEqual, phyA.MIC)
+
+			ok, err := phyA.ValidateMICWithContext(fCtx)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Then the LoRaWAN 1.1 uplink MIC equals the MIC calculated by SetMIC", func() {
+			phyA := newTestDataUp()
+			phyB := newTestDataUp()
+			So(phyA.SetMIC(fNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKey(sNwkSIntKey), WithConfFCnt(3), WithTxDR(2), WithTxCh(1)), ShouldBeNil)
+			So(phyB.SetMICWithContext(fCtx, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKeyHandle(sCtx), WithConfFCnt(3), WithTxDR(2), WithTxCh(1)), ShouldBeNil)
+			So(phyB.MIC, ShouldEqual, phyA.MIC)
+
+			Convey("Then SetMIC accepts the SNwkSIntKey context too", func() {
+				phyC := newTestDataUp()
+				So(phyC.SetMIC(fNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKeyHandle(sCtx), WithConfFCnt(3), WithTxDR(2), WithTxCh(1)), ShouldBeNil)
+				So(phyC.MIC, ShouldEqual, phyA.MIC)
+			})
+
+			Convey("Then SetMICWithContext accepts the SNwkSIntKey as AES128Key too", func() {
+				phyC := newTestDataUp()
+				So(phyC.SetMICWithContext(fCtx, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKey(sNwkSIntKey), WithConfFCnt(3), WithTxDR(2), WithTxCh(1)), ShouldBeNil)
+				So(phyC.MIC, ShouldEqual, phyA.MIC)
+			})
+		})
+
+		Convey("Then the LoRaWAN 1.1 downlink MIC equals the MIC calculated by SetMIC", func() {
+			phyA := newTestDataUp()
+			phyA.MHDR.MType = UnconfirmedDataDown
+			phyB := newTestDataUp()
+			phyB.MHDR.MType = UnconfirmedDataDown
+			So(phyA.SetMIC(sNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithConfFCnt(3)), ShouldBeNil)
+			So(phyB.SetMICWithContext(sCtx, WithMACVersion(LoRaWAN1_1), WithConfFCnt(3)), ShouldBeNil)
+			So(phyB.MIC, ShouldEqual, phyA.MIC)
+		})
+
+		Convey("Then the encrypted FRMPayload equals the FRMPayload encrypted by EncryptFRMPayload", func() {
+			phyA := newTestDataUp()
+			phyB := newTestDataUp()
+			So(phyA.EncryptFRMPayload(appSKey), ShouldBeNil)
+			So(phyB.EncryptFRMPayloadWithContext(appCtx), ShouldBeNil)
+			So(phyB, ShouldResemble, phyA)
+
+			Convey("Then DecryptFRMPayloadWithContext returns the original FRMPayload", func() {
+				So(phyB.DecryptFRMPayloadWithContext(appCtx), ShouldBeNil)
+				So(phyB, ShouldResemble, newTestDataUp())
+			})
+		})
+
+		Convey("Then SetMICWithContext, ValidateMICWithContext and EncryptFRMPayloadWithContext do not allocate", func() {
+			phy := newTestDataUp()
+			allocs := testing.AllocsPerRun(100, func() {
+				if err := phy.EncryptFRMPayloadWithContext(appCtx); err != nil {
+					panic(err)
+				}
+				if err := phy.SetMICWithContext(fCtx, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKeyHandle(sCtx), WithTxDR(2), WithTxCh(1)); err != nil {
+					panic(err)
+				}
+				if _, err := phy.ValidateMICWithContext(fCtx, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKeyHandle(sCtx), WithTxDR(2), WithTxCh(1)); err != nil {
+					panic(err)
+				}
+			})
+			So(allocs, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a slice with a larger capacity than its length", t, func() {
+		b := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+		data := b[0:4]
+
+		Convey("Then EncryptFRMPayload does not modify the bytes beyond its length", func() {
+			_, err := EncryptFRMPayload(AES128Key{}, true, DevAddr{1, 2, 3, 4}, 1, data)
+			So(err, ShouldBeNil)
+			So(b[4:], ShouldResemble, []byte{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+		})
+	})
+}
+
+func BenchmarkSetMIC(b *testing.B) {
+	key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := phy.SetMIC(key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetMICWithContext(b *testing.B) {
+	ctx, err := NewCipherContext(AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	if err != nil {
+		b.Fatal(err)
+	}
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := phy.SetMICWithContext(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetMICWithContextLoRaWAN11(b *testing.B) {
+	fCtx, err := NewCipherContext(AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	if err != nil {
+		b.Fatal(err)
+	}
+	sCtx, err := NewCipherContext(AES128Key{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := phy.SetMICWithContext(fCtx, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKeyHandle(sCtx), WithTxDR(2), WithTxCh(1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateMICWithContext(b *testing.B) {
+	ctx, err := NewCipherContext(AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	if err != nil {
+		b.Fatal(err)
+	}
+	phy := newTestDataUp()
+	if err := phy.SetMICWithContext(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if ok, err := phy.ValidateMICWithContext(ctx); err != nil || !ok {
+			b.Fatal("invalid MIC", err)
+		}
+	}
+}
+
+func BenchmarkEncryptFRMPayload(b *testing.B) {
+	key := AES128Key{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := phy.EncryptFRMPayload(key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncryptFRMPayloadWithContext(b *testing.B) {
+	ctx, err := NewCipherContext(AES128Key{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2})
+	if err != nil {
+		b.Fatal(err)
+	}
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := phy.EncryptFRMPayloadWithContext(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Codec decodes LoRaWAN frames using its own MAC command registry,
+// proprietary frame handlers and options. This makes it possible to use
+// different MAC command sets within the same process (e.g. per network
+// server or within tests). The UnmarshalBinary methods use the DefaultCodec.
+type Codec struct {
+	mu          sync.RWMutex
+	macCommands map[bool]map[CID]macPayloadInfo
+	rawFOpts    bool
+	strict      bool
+	macVersion  *MACVersion
+
+	proprietaryHandlers map[Major]ProprietaryHandler
+	aliasDataPayload    bool
+}
+
+// CodecOption defines an option for NewCodec.
+type CodecOption func(*Codec)
+
+// WithoutMACCommands removes the LoRaWAN MAC commands from the registry of
+// the codec. Use WithMACCommand or Codec.RegisterMACCommand to register the
+// MAC commands that must be decoded.
+func WithoutMACCommands() CodecOption {
+	return func(c *Codec) {
+		c.macCommands = map[bool]map[CID]macPayloadInfo{
+			false: {},
+			true:  {},
+		}
+	}
+}
+
+// WithMACCommand registers the given MAC command with the codec, replacing
+// an existing MAC command with the same CID and direction. See
+// Codec.RegisterMACCommand for the meaning of the arguments.
+func WithMACCommand(uplink bool, cid CID, size int, payload func() MACCommandPayload) CodecOption {
+	return func(c *Codec) {
+		c.macCommands[uplink][cid] = macPayloadInfo{size: size, payload: payload}
+	}
+}
+
+// WithRawFOpts sets the codec to store the FOpts bytes of decoded
+// PHYPayloads in FHDR.RawFOpts (see PHYPayload.UnmarshalBinaryRawFOpts).
+func WithRawFOpts() CodecOption {
+	return func(c *Codec) {
+		c.rawFOpts = true
+	}
+}
+
+// WithStrictMACCommands sets the codec to return an error when decoding an
+// unknown MAC command. By default, decoding stops at the first unknown MAC
+// command and the remaining bytes are stored as UnknownMACCommands payload.
+func WithStrictMACCommands() CodecOption {
+	return func(c *Codec) {
+		c.strict = true
+	}
+}
+
+// WithAliasedDataPayload sets the codec to not copy the decoded bytes.
+// Instead, DataPayload.Bytes (e.g. the FRMPayload) and the bytes over which
+// the MIC is validated refer to the slice passed to UnmarshalPHYPayload,
+// which avoids two allocations per frame. The caller must not modify or
+// re-use this slice (e.g. a receive buffer) while the PHYPayload is in use.
+// Note that encrypting or decrypting the FRMPayload of such PHYPayload
+// modifies the slice in-place, the MIC must therefore be validated before
+// decrypting the FRMPayload.
+func WithAliasedDataPayload() CodecOption {
+	return func(c *Codec) {
+		c.aliasDataPayload = true
+	}
+}
+
+// unmarshalDataPayload decodes the given data into a DataPayload. Unless
+// aliasDataPayload is set, the data is copied. The capacity of an aliased
+// slice is limited so that appending to it does not overwrite the MIC.
+func (c *Codec) unmarshalDataPayload(data []byte) *DataPayload {
+	if c.aliasDataPayload {
+		return &DataPayload{Bytes: data[:len(data):len(data)]}
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+	return &DataPayload{Bytes: b}
+}
+
+// macCommandVersions contains the MAC version by which a LoRaWAN MAC
+// command was introduced. MAC commands which are not listed are defined
+// since LoRaWAN 1.0.0.
+var macCommandVersions = map[CID]MACVersion{
+	ResetInd:            LoRaWAN1_1,
+	TXParamSetupReq:     LoRaWAN1_0_2,
+	DLChannelReq:        LoRaWAN1_0_2,
+	RekeyInd:            LoRaWAN1_1,
+	ADRParamSetupReq:    LoRaWAN1_1,
+	DeviceTimeReq:       LoRaWAN1_0_3,
+	ForceRejoinReq:      LoRaWAN1_1,
+	RejoinParamSetupReq: LoRaWAN1_1,
+}
+
+// macCommandRemovedVersions contains the MAC version by which a LoRaWAN MAC
+// command was removed.
+var macCommandRemovedVersions = map[CID]MACVersion{
+	BeaconTimingReq: LoRaWAN1_0_3,
+}
+
+// WithCodecMACVersion sets the LoRaWAN MAC version of the codec:
+//
+//   - LoRaWAN MAC commands which are not defined by this version (not yet
+//     introduced or already removed) are removed from the registry and are
+//     decoded as unknown MAC commands
+//   - for LoRaWAN 1.0.0 and 1.0.1, bit 4 of the uplink FCtrl is decoded as
+//     RFU bit instead of ClassB
+//   - for LoRaWAN 1.0.x, the OptNeg bit of the join-accept DLSettings is
+//     decoded as RFU bit, so that the LoRaWAN 1.0 join-accept MIC is used
+//   - it is the default MAC version for the MIC calculation of decoded
+//     PHYPayloads (see WithMACVersion)
+//   - MarshalPHYPayload returns an error when the PHYPayload contains
+//     fields that are not defined by this version
+//
+// Without this option, the codec decodes all MAC commands and fields.
+func WithCodecMACVersion(v MACVersion) CodecOption {
+	return func(c *Codec) {
+		c.macVersion = &v
+
+		for uplink := range c.macCommands {
+			for cid := range c.macCommands[uplink] {
+				if cid >= 0x80 {
+					continue
+				}
+				removed, ok := macCommandRemovedVersions[cid]
+				if macCommandVersions[cid] > v || (ok && removed <= v) {
+					delete(c.macCommands[uplink], cid)
+				}
+			}
+		}
+	}
+}
+
+// DefaultCodec is the codec used by the UnmarshalBinary methods,
+// RegisterMACCommand and RegisterProprietaryHandler.
+var DefaultCodec = NewCodec()
+
+// NewCodec returns a new Codec. Unless WithoutMACCommands is given, the
+// MAC command registry contains the LoRaWAN MAC commands. Options are
+// applied in the given order.
+func NewCodec(opts ...CodecOption) *Codec {
+	c := Codec{
+		macCommands: map[bool]map[CID]macPayloadInfo{
+			false: {},
+			true:  {},
+		},
+		proprietaryHandlers: make(map[Major]ProprietaryHandler),
+	}
+
+	for uplink, cmds := range macPayloadRegistry {
+		for cid, info := range cmds {
+			c.macCommands[uplink][cid] = info
+		}
+	}
+
+	for _, o := range opts {
+		o(&c)
+	}
+
+	return &c
+}
+
+// RegisterMACCommand registers a MAC command with the codec for the given
+// direction. size is the size of the payload in bytes and payload must
+// return a new instance of the payload type. For MAC commands without
+// payload, size must be 0 and payload nil. An error is returned when a MAC
+// command is already registered for the given CID and direction.
+func (c *Codec) RegisterMACCommand(uplink bool, cid CID, size int, payload func() MACCommandPayload) error {
+	if size < 0 || (size == 0) != (payload == nil) {
+		return errors.New("lorawan: payload must be set when size > 0 and nil when size is 0")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.macCommands[uplink][cid]; ok {
+		return fmt.Errorf("lorawan: MAC command already registered for uplink=%v and CID=%v", uplink, cid)
+	}
+	c.macCommands[uplink][cid] = macPayloadInfo{size: size, payload: payload}
+	return nil
+}
+
+// getMACPayloadAndSize returns a new MACCommandPayload instance and it's
+// size. For MAC commands without payload, the MACCommandPayload is nil.
+func (c *Codec) getMACPayloadAndSize(uplink bool, cid CID) (MACCommandPayload, int, error) {
+	c.mu.RLock()
+	v, ok := c.macCommands[uplink][cid]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, 0, newError(ErrUnknownMACCommand, "lorawan: payload unknown for uplink=%v and CID=%v", uplink, cid)
+	}
+	if v.payload == nil {
+		return nil, 0, nil
+	}
+
+	return v.payload(), v.size, nil
+}
+
+// getMACPayloadSize returns the payload size of the given MAC command and
+// if it is registered with the codec.
+func (c *Codec) getMACPayloadSize(uplink bool, cid CID) (int, bool) {
+	c.mu.RLock()
+	v, ok := c.macCommands[uplink][cid]
+	c.mu.RUnlock()
+	return v.size, ok
+}
+
+// unmarshalMACCommands decodes the given FOpts or FRMPayload (FPort=0)
+// bytes into MAC commands. Unless the codec is strict, decoding stops at
+// the first unknown MAC command, which will hold the remaining bytes as
+// UnknownMACCommands payload.
+func (c *Codec) unmarshalMACCommands(uplink bool, data []byte) ([]MACCommand, error) {
+	var out []MACCommand
+
+	for i := 0; i < len(data); i++ {
+		pLen := len(data[i:]) - 1
+		if s, ok := c.getMACPayloadSize(uplink, CID(data[i])); !ok {
+			if c.strict {
+				return nil, wrapDecodeIndexError(len(out), i, newError(ErrUnknownMACCommand, "lorawan: unknown MAC command with CID=%v", CID(data[i])))
+			}
+		} else {
+			pLen = s
+		}
+
+		// check if the remaining bytes are >= CID byte + payload size
+		if len(data[i:]) < pLen+1 {
+			return nil, wrapDecodeIndexError(len(out), i, newError(ErrTooShort, "lorawan: not enough remaining bytes"))
+		}
+
+		mc := MACCommand{}
+		if err := mc.unmarshalBinary(c, uplink, data[i:i+1+pLen]); err != nil {
+			return nil, wrapDecodeIndexError(len(out), i, err)
+		}
+		out = append(out, mc)
+
+		// go to the next command (skip the payload bytes of the current command)
+		i = i + pLen
+	}
+
+	return out, nil
+}
+
+// validateMHDR returns an error when the MHDR is not defined by the MAC
+// version of the codec.
+func (c *Codec) validateMHDR(h MHDR) error {
+	if c.macVersion == nil || h.MType == Proprietary {
+		return nil
+	}
+	if h.Major != LoRaWANR1 {
+		return newError(ErrValueOutOfRange, "lorawan: major version %d is not supported", h.Major)
+	}
+	if h.MType == RejoinRequest && *c.macVersion < LoRaWAN1_1 {
+		return newError(ErrValueOutOfRange, "lorawan: MType RejoinRequest is not defined by LoRaWAN %s", *c.macVersion)
+	}
+	return nil
+}
+
+// validateMACCommands returns an error when one of the given LoRaWAN MAC
+// commands is not registered with the codec.
+func (c *Codec) validateMACCommands(uplink bool, cmds []MACCommand) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, cmd := range cmds {
+		if _, ok := cmd.Payload.(*UnknownMACCommands); ok || cmd.CID >= 0x80 {
+			continue
+		}
+		if _, ok := c.macCommands[uplink][cmd.CID]; !ok {
+			return fmt.Errorf("lorawan: MAC command with CID=%v is not defined by LoRaWAN %s", cmd.CID, *c.macVersion)
+		}
+	}
+	return nil
+}
+
+// MarshalPHYPayload encodes the PHYPayload into binary form. When the
+// codec has a MAC version (see WithCodecMACVersion), an error is returned
+// when the PHYPayload contains a MAC command, MType, FCtrl ClassB bit or
+// join-accept OptNeg bit which is not defined by this version.
+func (c *Codec) MarshalPHYPayload(p PHYPayload) ([]byte, error) {
+	if c.macVersion == nil {
+		return p.MarshalBinary()
+	}
+
+	if err := c.validateMHDR(p.MHDR); err != nil {
+		return nil, err
+	}
+
+	switch pl := p.MACPayload.(type) {
+	case *MACPayload:
+		if p.isUplink() && pl.FHDR.FCtrl.ClassB && *c.macVersion < LoRaWAN1_0_2 {
+			return nil, fmt.Errorf("lorawan: ClassB is not defined by LoRaWAN %s", *c.macVersion)
+		}
+		if err := c.validateMACCommands(p.isUplink(), pl.FHDR.FOpts); err != nil {
+			return nil, err
+		}
+		for _, fp := range pl.FRMPayload {
+			if mac, ok := fp.(*MACCommand); ok {
+				if err := c.validateMACCommands(p.isUplink(), []MACCommand{*mac}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case *JoinAcceptPayload:
+		if pl.DLSettings.OptNeg && *c.macVersion < LoRaWAN1_1 {
+			return nil, fmt.Errorf("lorawan: OptNeg is not defined by LoRaWAN %s", *c.macVersion)
+		}
+	}
+
+	return p.MarshalBinary()
+}
+
+// UnmarshalPHYPayload decodes the PHYPayload from binary form. The codec is
+// also used for decoding the MAC commands by DecryptFRMPayload and
+// DecryptFOpts. On error, a *DecodeError is returned.
+func (c *Codec) UnmarshalPHYPayload(p *PHYPayload, data []byte) error {
+	return p.unmarshalBinary(c, c.rawFOpts, data)
+}
+
+// UnmarshalPHYPayloadJSON decodes the PHYPayload from JSON (see
+// PHYPayload.UnmarshalJSON). The MAC command payloads are looked up in the
+// registry of the codec, which is also used by DecryptFRMPayload and
+// DecryptFOpts.
+func (c *Codec) UnmarshalPHYPayloadJSON(p *PHYPayload, data []byte) error {
+	return p.unmarshalJSON(c, data)
+}
+
+// UnmarshalMACPayload decodes the MACPayload from binary form. On error, a
+// *DecodeError is returned.
+func (c *Codec) UnmarshalMACPayload(p *MACPayload, uplink bool, data []byte) error {
+	if err := p.unmarshalBinary(c, uplink, c.rawFOpts, data); err != nil {
+		return wrapDecodeError("MACPayload", 0, err)
+	}
+	return nil
+}
+
+// UnmarshalFHDR decodes the FHDR from binary form. On error, a
+// *DecodeError is returned.
+func (c *Codec) UnmarshalFHDR(h *FHDR, uplink bool, data []byte) error {
+	if err := h.unmarshalBinary(c, uplink, c.rawFOpts, data); err != nil {
+		return wrapDecodeError("FHDR", 0, err)
+	}
+	return nil
+}
+
+// UnmarshalMACCommand decodes the MACCommand from binary form. On error, a
+// *DecodeError is returned.
+func (c *Codec) UnmarshalMACCommand(m *MACCommand, uplink bool, data []byte) error {
+	if err := m.unmarshalBinary(c, uplink, data); err != nil {
+		return wrapDecodeError("MACCommand", 0, err)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package lorawan
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCodec(t *testing.T) {
+	Convey("Given two codecs, of which one has a proprietary uplink MAC command with CID=0x80 registered", t, func() {
+		newPayload := func() MACCommandPayload { return &testProprietaryMACPayload{} }
+
+		codecA := NewCodec()
+		codecB := NewCodec()
+		So(codecB.RegisterMACCommand(true, 0x80, 2, newPayload), ShouldBeNil)
+
+		Convey("Then registering it again returns an error", func() {
+			So(codecB.RegisterMACCommand(true, 0x80, 2, newPayload), ShouldResemble, errors.New("lorawan: MAC command already registered for uplink=true and CID=128"))
+		})
+
+		Convey("Then a MAC command without payload must be registered with size 0 and a nil payload", func() {
+			So(codecB.RegisterMACCommand(true, 0x81, 0, nil), ShouldBeNil)
+			So(codecB.RegisterMACCommand(true, 0x82, 0, newPayload), ShouldResemble, errors.New("lorawan: payload must be set when size > 0 and nil when size is 0"))
+		})
+
+		Convey("Then the MAC command is not registered with the other codec or DefaultCodec", func() {
+			_, _, err := codecA.getMACPayloadAndSize(true, 0x80)
+			So(err, ShouldNotBeNil)
+			_, _, err = DefaultCodec.getMACPayloadAndSize(true, 0x80)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the MAC command is only decoded by the codec it is registered with", func() {
+			var h FHDR
+			So(codecB.UnmarshalFHDR(&h, true, []byte{4, 3, 2, 1, 3, 0, 0, 0x80, 0x34, 0x12}), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: 0x80, Payload: &testProprietaryMACPayload{Value: 0x1234}},
+			})
+
+			h = FHDR{}
+			So(codecA.UnmarshalFHDR(&h, true, []byte{4, 3, 2, 1, 3, 0, 0, 0x80, 0x34, 0x12}), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: 0x80, Payload: &UnknownMACCommands{Bytes: []byte{0x34, 0x12}}},
+			})
+		})
+
+		Convey("Given an uplink with the MAC command in the encrypted FRMPayload", func() {
+			key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+			fPort := uint8(0)
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: UnconfirmedDataUp,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{
+						DevAddr: DevAddr{1, 2, 3, 4},
+					},
+					FPort: &fPort,
+					FRMPayload: []Payload{
+						&MACCommand{CID: 0x80, Payload: &testProprietaryMACPayload{Value: 0x1234}},
+					},
+				},
+			}
+			So(phy.EncryptFRMPayload(key), ShouldBeNil)
+			b, err := phy.MarshalBinary()
+			So(err, ShouldBeNil)
+
+			Convey("Then DecryptFRMPayload uses the codec which decoded the PHYPayload", func() {
+				var out PHYPayload
+				So(codecB.UnmarshalPHYPayload(&out, b), ShouldBeNil)
+				So(out.DecryptFRMPayload(key), ShouldBeNil)
+				So(out.MACPayload.(*MACPayload).FRMPayload, ShouldResemble, []Payload{
+					&MACCommand{CID: 0x80, Payload: &testProprietaryMACPayload{Value: 0x1234}},
+				})
+
+				out = PHYPayload{}
+				So(out.UnmarshalBinary(b), ShouldBeNil)
+				So(out.DecryptFRMPayload(key), ShouldBeNil)
+				So(out.MACPayload.(*MACPayload).FRMPayload, ShouldResemble, []Payload{
+					&MACCommand{CID: 0x80, Payload: &UnknownMACCommands{Bytes: []byte{0x34, 0x12}}},
+				})
+			})
+		})
+	})
+
+	Convey("Given a codec without MAC commands, but with a test double for LinkCheckReq", t, func() {
+		codec := NewCodec(
+			WithoutMACCommands(),
+			WithMACCommand(true, LinkCheckReq, 2, func() MACCommandPayload { return &testProprietaryMACPayload{} }),
+		)
+
+		Convey("Then LinkCheckReq is decoded using the test double", func() {
+			var mac MACCommand
+			So(codec.UnmarshalMACCommand(&mac, true, []byte{0x02, 0x01, 0x00}), ShouldBeNil)
+			So(mac, ShouldResemble, MACCommand{CID: LinkCheckReq, Payload: &testProprietaryMACPayload{Value: 1}})
+		})
+
+		Convey("Then the LoRaWAN MAC commands are not registered", func() {
+			_, _, err := codec.getMACPayloadAndSize(false, LinkADRReq)
+			So(err, ShouldNotBeNil)
+			_, _, err = DefaultCodec.getMACPayloadAndSize(false, LinkADRReq)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a codec with the WithRawFOpts option", t, func() {
+		codec := NewCodec(WithRawFOpts())
+
+		Convey("Then the FOpts are stored as RawFOpts", func() {
+			var macPL MACPayload
+			So(codec.UnmarshalMACPayload(&macPL, false, []byte{4, 3, 2, 1, 3, 0, 0, 6, 7, 8}), ShouldBeNil)
+			So(macPL.FHDR.RawFOpts, ShouldResemble, []byte{6, 7, 8})
+			So(macPL.FHDR.FOpts, ShouldBeNil)
+		})
+	})
+
+	Convey("Given downlink FOpts with a LinkCheckAns, an unknown MAC command (CID=0x7f) and a DevStatusReq", t, func() {
+		b := []byte{4, 3, 2, 1, 6, 0, 0, 0x02, 0x05, 0x02, 0x7f, 0x01, 0x06}
+
+		Convey("Then the DefaultCodec stores the remaining bytes as UnknownMACCommands", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(false, b), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: LinkCheckAns, Payload: &LinkCheckAnsPayload{Margin: 5, GwCnt: 2}},
+				{CID: 0x7f, Payload: &UnknownMACCommands{Bytes: []byte{0x01, 0x06}}},
+			})
+
+			Convey("Then MarshalBinary returns the original bytes", func() {
+				out, err := h.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(out, ShouldResemble, b)
+			})
+		})
+
+		Convey("Then a strict codec returns an error containing the CID and offset", func() {
+			var h FHDR
+			So(NewCodec(WithStrictMACCommands()).UnmarshalFHDR(&h, false, b), ShouldResemble, &DecodeError{
+				Path:   "FHDR.FOpts[1]",
+				Offset: 10,
+				Err:    newError(ErrUnknownMACCommand, "lorawan: unknown MAC command with CID=127"),
+			})
+		})
+	})
+
+	Convey("Given a FRMPayload (FPort=0) with an uplink LinkCheckReq, DutyCycleAns and DeviceTimeReq", t, func() {
+		b := []byte{4, 3, 2, 1, 0, 0, 0, 0, 0x02, 0x04, 0x0d}
+
+		Convey("Then a strict codec decodes the MAC commands without payload", func() {
+			var macPL MACPayload
+			So(NewCodec(WithStrictMACCommands()).UnmarshalMACPayload(&macPL, true, b), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: LinkCheckReq},
+				&MACCommand{CID: DutyCycleAns},
+				&MACCommand{CID: DeviceTimeReq},
+			})
+		})
+	})
+
+	Convey("Given an uplink FRMPayload (FPort=0) with a TXParamSetupAns and DeviceTimeReq", t, func() {
+		b := []byte{4, 3, 2, 1, 0, 0, 0, 0, 0x09, 0x0d}
+
+		Convey("Then a LoRaWAN 1.0.2 codec decodes the DeviceTimeReq as unknown MAC command", func() {
+			var macPL MACPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_2)).UnmarshalMACPayload(&macPL, true, b), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: TXParamSetupAns},
+				&MACCommand{CID: DeviceTimeReq, Payload: &UnknownMACCommands{Bytes: []byte{}}},
+			})
+		})
+
+		Convey("Then a LoRaWAN 1.0.1 codec returns an error when it is strict", func() {
+			var macPL MACPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_1), WithStrictMACCommands()).UnmarshalMACPayload(&macPL, true, b), ShouldResemble, &DecodeError{
+				Path:   "MACPayload.FRMPayload[0]",
+				Offset: 8,
+				Err:    newError(ErrUnknownMACCommand, "lorawan: unknown MAC command with CID=9"),
+			})
+		})
+
+		Convey("Then a LoRaWAN 1.0.3 codec decodes both MAC commands", func() {
+			var macPL MACPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_3)).UnmarshalMACPayload(&macPL, true, b), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: TXParamSetupAns},
+				&MACCommand{CID: DeviceTimeReq},
+			})
+		})
+	})
+
+	Convey("Given a downlink MACPayload with a BeaconTimingAns (removed by LoRaWAN 1.0.3)", t, func() {
+		b := []byte{4, 3, 2, 1, 0, 0, 0, 0, 0x12, 1, 2, 3}
+
+		Convey("Then a LoRaWAN 1.0.2 codec decodes the BeaconTimingAns", func() {
+			var macPL MACPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_2)).UnmarshalMACPayload(&macPL, false, b), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: BeaconTimingAns, Payload: &BeaconTimingAnsPayload{Delay: 513, Channel: 3}},
+			})
+		})
+
+		Convey("Then a LoRaWAN 1.0.3 codec decodes it as unknown MAC command", func() {
+			var macPL MACPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_3)).UnmarshalMACPayload(&macPL, false, b), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: BeaconTimingAns, Payload: &UnknownMACCommands{Bytes: []byte{1, 2, 3}}},
+			})
+		})
+	})
+
+	Convey("Given an uplink with bit 4 of the FCtrl set", t, func() {
+		b := []byte{0x40, 4, 3, 2, 1, 0x90, 0, 0, 1, 2, 3, 4}
+
+		Convey("Then a LoRaWAN 1.0.1 codec decodes it as RFU bit", func() {
+			var phy PHYPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_1)).UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+			So(phy.MACPayload.(*MACPayload).FHDR.FCtrl, ShouldResemble, FCtrl{ADR: true, RFU: true})
+
+			out, err := phy.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, b)
+		})
+
+		Convey("Then a LoRaWAN 1.0.2 codec decodes it as ClassB", func() {
+			var phy PHYPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_2)).UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+			So(phy.MACPayload.(*MACPayload).FHDR.FCtrl, ShouldResemble, FCtrl{ADR: true, ClassB: true})
+
+			Convey("Then MarshalPHYPayload of a LoRaWAN 1.0.1 codec returns an error", func() {
+				_, err := NewCodec(WithCodecMACVersion(LoRaWAN1_0_1)).MarshalPHYPayload(phy)
+				So(err, ShouldResemble, errors.New("lorawan: ClassB is not defined by LoRaWAN 1.0.1"))
+			})
+		})
+	})
+
+	Convey("Given a rejoin-request", t, func() {
+		b := append([]byte{0xc0, 0x00}, make([]byte, 17)...)
+
+		Convey("Then a LoRaWAN 1.0.4 codec returns an error", func() {
+			var phy PHYPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_4)).UnmarshalPHYPayload(&phy, b), ShouldResemble, &DecodeError{
+				Path: "PHYPayload.MHDR",
+				Err:  newError(ErrValueOutOfRange, "lorawan: MType RejoinRequest is not defined by LoRaWAN 1.0.4"),
+			})
+		})
+
+		Convey("Then a LoRaWAN 1.1 codec decodes the rejoin-request", func() {
+			var phy PHYPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_1)).UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+			So(phy.MACPayload, ShouldHaveSameTypeAs, &RejoinRequestType02Payload{})
+		})
+	})
+
+	Convey("Given an encrypted join-accept with the DLSettings bit 7 set and the LoRaWAN 1.0 MIC", t, func() {
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		jaPL := JoinAcceptPayload{
+			DevAddr:    DevAddr{1, 2, 3, 4},
+			DLSettings: DLsettings{OptNeg: true, RX2DataRate: 3},
+		}
+		plBytes, err := jaPL.MarshalBinary()
+		So(err, ShouldBeNil)
+		mic, err := calculateCMAC(SoftwareKeyProvider{}, key, append([]byte{0x20}, plBytes...))
+		So(err, ShouldBeNil)
+
+		phy := PHYPayload{
+			MHDR:       MHDR{MType: JoinAccept, Major: LoRaWANR1},
+			MACPayload: &jaPL,
+		}
+		copy(phy.MIC[:], mic)
+		So(phy.EncryptJoinAcceptPayload(key), ShouldBeNil)
+		b, err := phy.MarshalBinary()
+		So(err, ShouldBeNil)
+
+		Convey("Then a LoRaWAN 1.0.3 codec does not decode the RFU bit as OptNeg and validates the LoRaWAN 1.0 MIC", func() {
+			var out PHYPayload
+			So(NewCodec(WithCodecMACVersion(LoRaWAN1_0_3)).UnmarshalPHYPayload(&out, b), ShouldBeNil)
+			So(out.DecryptJoinAcceptPayload(key), ShouldBeNil)
+			So(out.MACPayload.(*JoinAcceptPayload).DLSettings, ShouldResemble, DLsettings{RX2DataRate: 3, RFU: 0x80})
+
+			ok, err := out.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Then the DefaultCodec decodes the OptNeg bit, but validates the LoRaWAN 1.0 MIC by default", func() {
+			var out PHYPayload
+			So(out.UnmarshalBinary(b), ShouldBeNil)
+			So(out.DecryptJoinAcceptPayload(key), ShouldBeNil)
+			So(out.MACPayload.(*JoinAcceptPayload).DLSettings.OptNeg, ShouldBeTrue)
+
+			ok, err := out.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			_, err = out.ValidateMIC(key, WithMACVersion(LoRaWAN1_1))
+			So(err, ShouldResemble, errors.New("lorawan: JoinReqType must be set for the LoRaWAN 1.1 join-accept MIC"))
+		})
+
+		Convey("Then MarshalPHYPayload of a LoRaWAN 1.0.3 codec returns an error for the decrypted join-accept with OptNeg set", func() {
+			So(phy.DecryptJoinAcceptPayload(key), ShouldBeNil)
+			_, err := NewCodec(WithCodecMACVersion(LoRaWAN1_0_3)).MarshalPHYPayload(phy)
+			So(err, ShouldResemble, errors.New("lorawan: OptNeg is not defined by LoRaWAN 1.0.3"))
+		})
+	})
+
+	Convey("Given an uplink decoded by a LoRaWAN 1.1 codec", t, func() {
+		b := []byte{0x40, 4, 3, 2, 1, 0, 0, 0, 1, 2, 3, 4}
+		var phy PHYPayload
+		So(NewCodec(WithCodecMACVersion(LoRaWAN1_1)).UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+
+		Convey("Then the LoRaWAN 1.1 MIC is calculated by default", func() {
+			So(phy.SetMIC(AES128Key{}), ShouldResemble, errors.New("lorawan: SNwkSIntKey must be set for the LoRaWAN 1.1 uplink MIC"))
+			So(phy.SetMIC(AES128Key{}, WithMACVersion(LoRaWAN1_0)), ShouldBeNil)
+		})
+	})
+
+	Convey("Given an uplink with a DeviceTimeReq in the FOpts", t, func() {
+		phy := PHYPayload{
+			MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+			MACPayload: &MACPayload{
+				FHDR: FHDR{
+					DevAddr: DevAddr{1, 2, 3, 4},
+					FOpts:   []MACCommand{{CID: DeviceTimeReq}},
+				},
+			},
+		}
+
+		Convey("Then MarshalPHYPayload of a LoRaWAN 1.0.2 codec returns an error", func() {
+			_, err := NewCodec(WithCodecMACVersion(LoRaWAN1_0_2)).MarshalPHYPayload(phy)
+			So(err, ShouldResemble, errors.New("lorawan: MAC command with CID=13 is not defined by LoRaWAN 1.0.2"))
+		})
+
+		Convey("Then MarshalPHYPayload of a LoRaWAN 1.0.3 codec returns the PHYPayload in binary form", func() {
+			b, err := NewCodec(WithCodecMACVersion(LoRaWAN1_0_3)).MarshalPHYPayload(phy)
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{0x40, 4, 3, 2, 1, 1, 0, 0, 0x0d, 0, 0, 0, 0})
+		})
+	})
+
+	Convey("Given an uplink and a proprietary frame decoded by a codec with WithAliasedDataPayload", t, func() {
+		codec := NewCodec(WithAliasedDataPayload())
+		up := []byte{0x40, 4, 3, 2, 1, 0, 0, 0, 10, 1, 2, 3, 1, 2, 3, 4}
+		prop := []byte{0xe0, 1, 2, 3, 1, 2, 3, 4}
+
+		var upPHY, propPHY PHYPayload
+		So(codec.UnmarshalPHYPayload(&upPHY, up), ShouldBeNil)
+		So(codec.UnmarshalPHYPayload(&propPHY, prop), ShouldBeNil)
+
+		Convey("Then the DataPayload bytes refer to the given slices", func() {
+			upDP := upPHY.MACPayload.(*MACPayload).FRMPayload[0].(*DataPayload)
+			So(upDP.Bytes, ShouldResemble, []byte{1, 2, 3})
+			So(&upDP.Bytes[0], ShouldEqual, &up[9])
+			So(cap(upDP.Bytes), ShouldEqual, 3)
+
+			propDP := propPHY.MACPayload.(*DataPayload)
+			So(propDP.Bytes, ShouldResemble, []byte{1, 2, 3})
+			So(&propDP.Bytes[0], ShouldEqual, &prop[1])
+			So(cap(propDP.Bytes), ShouldEqual, 3)
+
+			So(&upPHY.raw[0], ShouldEqual, &up[0])
+			So(cap(upPHY.raw), ShouldEqual, len(up))
+		})
+
+		Convey("Then decoding does not copy the frame and the FRMPayload", func() {
+			decode := func(c *Codec) float64 {
+				return testing.AllocsPerRun(100, func() {
+					var phy PHYPayload
+					if err := c.UnmarshalPHYPayload(&phy, up); err != nil {
+						panic(err)
+					}
+				})
+			}
+			So(decode(codec), ShouldEqual, decode(NewCodec())-2)
+		})
+
+		Convey("Then the DefaultCodec copies the DataPayload bytes", func() {
+			var phy PHYPayload
+			So(DefaultCodec.UnmarshalPHYPayload(&phy, up), ShouldBeNil)
+			dp := phy.MACPayload.(*MACPayload).FRMPayload[0].(*DataPayload)
+			So(dp.Bytes, ShouldResemble, []byte{1, 2, 3})
+			So(&dp.Bytes[0], ShouldNotEqual, &up[9])
+		})
+	})
+
+	Convey("Given four MAC commands without payload", t, func() {
+		b := []byte{byte(LinkCheckReq), byte(LinkCheckReq), byte(LinkCheckReq), byte(LinkCheckReq)}
+
+		Convey("Then decoding does not allocate per MAC command", func() {
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := DefaultCodec.unmarshalMACCommands(true, b); err != nil {
+					panic(err)
+				}
+			})
+			So(allocs, ShouldBeLessThan, len(b))
+		})
+	})
+
+	Convey("Given a set of MAC versions", t, func() {
+		So(LoRaWAN1_0.String(), ShouldEqual, "1.0.0")
+		So(LoRaWAN1_0_4.String(), ShouldEqual, "1.0.4")
+		So(LoRaWAN1_1.String(), ShouldEqual, "1.1")
+	})
+}
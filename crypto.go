@@ -0,0 +1,231 @@
+package lorawan
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"github.com/jacobsa/crypto/cmac"
+)
+
+// KeyHandle identifies a key within a KeyProvider. For the
+// SoftwareKeyProvider, the handle is the AES128Key itself. A provider backed
+// by a hardware security module (HSM) would typically use a key label or
+// slot reference, so that the key material never leaves the module.
+type KeyHandle interface{}
+
+// KeyProvider implements the cryptographic operations used by this package
+// for calculating the MIC, encrypting the FRMPayload, FOpts and join-accept
+// and for deriving keys.
+//
+// The operations are purpose specific, instead of exposing AES-ECB on any
+// key handle, so that an implementation backed by a secure module can
+// restrict the operations per key and never returns key material in the
+// clear. E.g. a join server implementation would only allow CMAC,
+// EncryptJoinAccept and DeriveKey for root keys. Note that
+// DecryptJoinAccept applies the AES encrypt operation to the given data, it
+// is only needed by end-devices (or for testing) and should not be allowed
+// for root keys by a join server implementation.
+type KeyProvider interface {
+	// CMAC returns the AES-CMAC of the concatenated data.
+	CMAC(key KeyHandle, data ...[]byte) ([]byte, error)
+
+	// EncryptJoinAccept encrypts the join-accept payload, including the
+	// MIC. The length of pt must be a multiple of 16 bytes. Note that the
+	// join-accept is encrypted using the AES decrypt operation, so that the
+	// device only needs to implement AES encrypt.
+	EncryptJoinAccept(key KeyHandle, pt []byte) ([]byte, error)
+
+	// DecryptJoinAccept decrypts the join-accept payload, including the
+	// MIC. The length of ct must be a multiple of 16 bytes.
+	DecryptJoinAccept(key KeyHandle, ct []byte) ([]byte, error)
+
+	// FRMPayloadKeyStream returns the first n bytes of the key stream for
+	// encrypting (or decrypting) the FRMPayload:
+	// aes128_encrypt(key, Ai) for i = 1..k, with
+	// Ai = 0x01 | 4 x 0x00 | Dir | DevAddr | FCnt | 0x00 | i
+	FRMPayloadKeyStream(key KeyHandle, uplink bool, devAddr DevAddr, fCnt uint32, n int) ([]byte, error)
+
+	// FOptsKeyStream returns the 16 byte key stream for encrypting (or
+	// decrypting) the LoRaWAN 1.1 FOpts: aes128_encrypt(NwkSEncKey, A), with
+	// A = 0x01 | 3 x 0x00 | 0x01 (NFCntDown / FCntUp) or 0x02 (AFCntDown) | Dir | DevAddr | FCnt | 0x00 | 0x01
+	FOptsKeyStream(key KeyHandle, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32) ([]byte, error)
+
+	// DeriveKey derives a new key, aes128_encrypt(key, b), and returns the
+	// handle of the derived key.
+	DeriveKey(key KeyHandle, b [16]byte) (KeyHandle, error)
+}
+
+// SoftwareKeyProvider implements the KeyProvider interface in memory.
+// Key handles must be of type AES128Key, *AES128Key or *CipherContext.
+type SoftwareKeyProvider struct{}
+
+// CMAC returns the AES-CMAC of the concatenated data.
+func (p SoftwareKeyProvider) CMAC(key KeyHandle, data ...[]byte) ([]byte, error) {
+	k, err := p.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := cmac.New(k[:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range data {
+		if _, err = hash.Write(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return hash.Sum([]byte{}), nil
+}
+
+// EncryptJoinAccept encrypts the join-accept payload, including the MIC.
+func (p SoftwareKeyProvider) EncryptJoinAccept(key KeyHandle, pt []byte) ([]byte, error) {
+	ct := make([]byte, len(pt))
+	// the join-accept is encrypted using the AES decrypt operation
+	if err := p.decryptBlocks(key, ct, pt); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// DecryptJoinAccept decrypts the join-accept payload, including the MIC.
+func (p SoftwareKeyProvider) DecryptJoinAccept(key KeyHandle, ct []byte) ([]byte, error) {
+	pt := make([]byte, len(ct))
+	if err := p.encryptBlocks(key, pt, ct); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// FRMPayloadKeyStream returns the first n bytes of the FRMPayload key
+// stream.
+func (p SoftwareKeyProvider) FRMPayloadKeyStream(key KeyHandle, uplink bool, devAddr DevAddr, fCnt uint32, n int) ([]byte, error) {
+	s := frmPayloadABlocks(uplink, devAddr, fCnt, n)
+	if err := p.encryptBlocks(key, s, s); err != nil {
+		return nil, err
+	}
+	return s[:n], nil
+}
+
+// FOptsKeyStream returns the FOpts key stream.
+func (p SoftwareKeyProvider) FOptsKeyStream(key KeyHandle, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32) ([]byte, error) {
+	s := fOptsABlock(aFCntDown, uplink, devAddr, fCnt)
+	if err := p.encryptBlocks(key, s[:], s[:]); err != nil {
+		return nil, err
+	}
+	return s[:], nil
+}
+
+// DeriveKey returns aes128_encrypt(key, b) as AES128Key.
+func (p SoftwareKeyProvider) DeriveKey(key KeyHandle, b [16]byte) (KeyHandle, error) {
+	var out AES128Key
+	if err := p.encryptBlocks(key, out[:], b[:]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// encryptBlocks encrypts src into dst using AES-128 in ECB mode.
+func (p SoftwareKeyProvider) encryptBlocks(key KeyHandle, dst, src []byte) error {
+	block, err := p.block(key, dst, src)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(src); i += 16 {
+		block.Encrypt(dst[i:i+16], src[i:i+16])
+	}
+	return nil
+}
+
+// decryptBlocks decrypts src into dst using AES-128 in ECB mode.
+func (p SoftwareKeyProvider) decryptBlocks(key KeyHandle, dst, src []byte) error {
+	block, err := p.block(key, dst, src)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(src); i += 16 {
+		block.Decrypt(dst[i:i+16], src[i:i+16])
+	}
+	return nil
+}
+
+// frmPayloadABlocks returns the A blocks for the FRMPayload key stream of
+// n bytes, padded to a multiple of 16 bytes.
+func frmPayloadABlocks(uplink bool, devAddr DevAddr, fCnt uint32, n int) []byte {
+	a := make([]byte, (n+15)/16*16)
+	for i := 0; i < len(a)/16; i++ {
+		b := a[i*16 : i*16+16]
+		b[0] = 0x01
+		if !uplink {
+			b[5] = 0x01
+		}
+		// little endian
+		b[6], b[7], b[8], b[9] = devAddr[3], devAddr[2], devAddr[1], devAddr[0]
+		binary.LittleEndian.PutUint32(b[10:14], fCnt)
+		b[15] = byte(i + 1)
+	}
+	return a
+}
+
+// fOptsABlock returns the A block for the FOpts key stream. The block is
+// constructed as defined by the LoRaWAN 1.1 errata.
+func fOptsABlock(aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32) [16]byte {
+	var a [16]byte
+	a[0] = 0x01
+	if aFCntDown {
+		a[4] = 0x02
+	} else {
+		a[4] = 0x01
+	}
+	if !uplink {
+		a[5] = 0x01
+	}
+	// little endian
+	a[6], a[7], a[8], a[9] = devAddr[3], devAddr[2], devAddr[1], devAddr[0]
+	binary.LittleEndian.PutUint32(a[10:14], fCnt)
+	a[15] = 0x01
+	return a
+}
+
+func (p SoftwareKeyProvider) key(key KeyHandle) (AES128Key, error) {
+	switch k := key.(type) {
+	case AES128Key:
+		return k, nil
+	case *AES128Key:
+		if k != nil {
+			return *k, nil
+		}
+	case *CipherContext:
+		if k != nil {
+			return k.key, nil
+		}
+	}
+	return AES128Key{}, errors.New("lorawan: key handle must be of type AES128Key")
+}
+
+func (p SoftwareKeyProvider) block(key KeyHandle, dst, src []byte) (cipher.Block, error) {
+	if len(src)%16 != 0 {
+		return nil, errors.New("lorawan: data must be a multiple of 16 bytes")
+	}
+	if len(dst) < len(src) {
+		return nil, errors.New("lorawan: dst must be at least the size of src")
+	}
+
+	k, err := p.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	if block.BlockSize() != 16 {
+		return nil, errors.New("lorawan: block-size of 16 bytes is expected")
+	}
+	return block, nil
+}
@@ -0,0 +1,251 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// labelKeyProvider is a stand-in for a HSM backed KeyProvider. Keys are
+// referenced by label and never returned to the caller.
+type labelKeyProvider struct {
+	keys map[string]AES128Key
+}
+
+func (p *labelKeyProvider) key(h KeyHandle) (AES128Key, error) {
+	label, ok := h.(string)
+	if !ok {
+		return AES128Key{}, errors.New("key handle must be a label")
+	}
+	key, ok := p.keys[label]
+	if !ok {
+		return AES128Key{}, fmt.Errorf("unknown key %s", label)
+	}
+	return key, nil
+}
+
+func (p *labelKeyProvider) CMAC(h KeyHandle, data ...[]byte) ([]byte, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	return SoftwareKeyProvider{}.CMAC(key, data...)
+}
+
+func (p *labelKeyProvider) EncryptJoinAccept(h KeyHandle, pt []byte) ([]byte, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	return SoftwareKeyProvider{}.EncryptJoinAccept(key, pt)
+}
+
+func (p *labelKeyProvider) DecryptJoinAccept(h KeyHandle, ct []byte) ([]byte, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	return SoftwareKeyProvider{}.DecryptJoinAccept(key, ct)
+}
+
+func (p *labelKeyProvider) FRMPayloadKeyStream(h KeyHandle, uplink bool, devAddr DevAddr, fCnt uint32, n int) ([]byte, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	return SoftwareKeyProvider{}.FRMPayloadKeyStream(key, uplink, devAddr, fCnt, n)
+}
+
+func (p *labelKeyProvider) FOptsKeyStream(h KeyHandle, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32) ([]byte, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	return SoftwareKeyProvider{}.FOptsKeyStream(key, aFCntDown, uplink, devAddr, fCnt)
+}
+
+func (p *labelKeyProvider) DeriveKey(h KeyHandle, b [16]byte) (KeyHandle, error) {
+	key, err := p.key(h)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := deriveKey(key, b)
+	if err != nil {
+		return nil, err
+	}
+	label := fmt.Sprintf("derived-%d", len(p.keys))
+	p.keys[label] = derived
+	return label, nil
+}
+
+// shortKeyStreamProvider is a misbehaving KeyProvider which returns key
+// streams of only 2 bytes.
+type shortKeyStreamProvider struct {
+	SoftwareKeyProvider
+}
+
+func (p shortKeyStreamProvider) FRMPayloadKeyStream(h KeyHandle, uplink bool, devAddr DevAddr, fCnt uint32, n int) ([]byte, error) {
+	s, err := p.SoftwareKeyProvider.FRMPayloadKeyStream(h, uplink, devAddr, fCnt, n)
+	return s[:2], err
+}
+
+func (p shortKeyStreamProvider) FOptsKeyStream(h KeyHandle, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32) ([]byte, error) {
+	s, err := p.SoftwareKeyProvider.FOptsKeyStream(h, aFCntDown, uplink, devAddr, fCnt)
+	return s[:2], err
+}
+
+func TestSoftwareKeyProvider(t *testing.T) {
+	Convey("Given a SoftwareKeyProvider", t, func() {
+		var provider SoftwareKeyProvider
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+		Convey("Then EncryptJoinAccept and DecryptJoinAccept accept an AES128Key and *AES128Key handle", func() {
+			pt := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+			ct, err := provider.EncryptJoinAccept(key, pt)
+			So(err, ShouldBeNil)
+			So(ct, ShouldNotResemble, pt)
+
+			out, err := provider.DecryptJoinAccept(&key, ct)
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, pt)
+		})
+
+		Convey("Then FRMPayloadKeyStream returns n bytes of the FRMPayload key stream", func() {
+			s, err := provider.FRMPayloadKeyStream(key, true, DevAddr{1, 2, 3, 4}, 1, 20)
+			So(err, ShouldBeNil)
+			So(s, ShouldHaveLength, 20)
+
+			data := make([]byte, 20)
+			out, err := EncryptFRMPayload(key, true, DevAddr{1, 2, 3, 4}, 1, data)
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, s)
+		})
+
+		Convey("Then FOptsKeyStream returns the FOpts key stream", func() {
+			s, err := provider.FOptsKeyStream(key, false, true, DevAddr{1, 2, 3, 4}, 1)
+			So(err, ShouldBeNil)
+
+			data := make([]byte, 15)
+			out, err := EncryptFOpts(key, false, true, DevAddr{1, 2, 3, 4}, 1, data)
+			So(err, ShouldBeNil)
+			So(s, ShouldHaveLength, 16)
+			So(out, ShouldResemble, s[:15])
+		})
+
+		Convey("Then a key stream shorter than the data returns an error", func() {
+			short := shortKeyStreamProvider{}
+			_, err := EncryptFRMPayloadWithProvider(short, key, true, DevAddr{1, 2, 3, 4}, 1, make([]byte, 3))
+			So(err, ShouldResemble, newError(ErrInvalidLength, "lorawan: the key stream is shorter than the data"))
+			_, err = EncryptFOptsWithProvider(short, key, false, true, DevAddr{1, 2, 3, 4}, 1, make([]byte, 3))
+			So(err, ShouldResemble, newError(ErrInvalidLength, "lorawan: the key stream is shorter than the data"))
+		})
+
+		Convey("Then DeriveKey returns an AES128Key", func() {
+			h, err := provider.DeriveKey(key, [16]byte{})
+			So(err, ShouldBeNil)
+			So(h, ShouldHaveSameTypeAs, AES128Key{})
+		})
+
+		Convey("Then an invalid key handle returns an error", func() {
+			_, err := provider.CMAC("my-key", []byte{1, 2, 3})
+			So(err, ShouldResemble, errors.New("lorawan: key handle must be of type AES128Key"))
+		})
+
+		Convey("Then a join-accept which is not a multiple of 16 bytes returns an error", func() {
+			_, err := provider.EncryptJoinAccept(key, make([]byte, 15))
+			So(err, ShouldResemble, errors.New("lorawan: data must be a multiple of 16 bytes"))
+		})
+	})
+}
+
+func TestKeyProvider(t *testing.T) {
+	Convey("Given a KeyProvider with NwkKey and AppKey stored by label", t, func() {
+		var rootKeys RootKeys
+		So(rootKeys.NwkKey.UnmarshalText([]byte("00112233445566778899aabbccddeeff")), ShouldBeNil)
+		So(rootKeys.AppKey.UnmarshalText([]byte("ffeeddccbbaa99887766554433221100")), ShouldBeNil)
+
+		provider := &labelKeyProvider{keys: map[string]AES128Key{
+			"nwk-key": rootKeys.NwkKey,
+			"app-key": rootKeys.AppKey,
+		}}
+
+		Convey("Then DeriveLoRaWAN11SessionKeysWithProvider returns handles to the expected keys", func() {
+			keys, err := DeriveLoRaWAN11SessionKeysWithProvider(provider, "nwk-key", "app-key", [3]byte{1, 2, 3}, EUI64{1, 2, 3, 4, 5, 6, 7, 8}, [2]byte{1, 2})
+			So(err, ShouldBeNil)
+			So(provider.keys[keys.FNwkSIntKey.(string)].String(), ShouldEqual, "6cc5224e67aac74fee5d71a56ccc9368")
+			So(provider.keys[keys.SNwkSIntKey.(string)].String(), ShouldEqual, "ee9badff96164ec5258108f6c1dd14bf")
+			So(provider.keys[keys.NwkSEncKey.(string)].String(), ShouldEqual, "c0c7471b05add21ecd8bf1df694ae5c1")
+			So(provider.keys[keys.AppSKey.(string)].String(), ShouldEqual, "4eea617d419e7bdf17d285021e07baa6")
+
+			Convey("Then the MIC and FRMPayload encryption equal the AES128Key based result", func() {
+				fPort := uint8(10)
+				newPHY := func() PHYPayload {
+					return PHYPayload{
+						MHDR: MHDR{
+							MType: UnconfirmedDataUp,
+							Major: LoRaWANR1,
+						},
+						MACPayload: &MACPayload{
+							FHDR: FHDR{
+								DevAddr: DevAddr{1, 2, 3, 4},
+								FCnt:    10,
+							},
+							FPort:      &fPort,
+							FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}}},
+						},
+					}
+				}
+				opts := []MICOption{WithMACVersion(LoRaWAN1_1), WithTxDR(5), WithTxCh(2)}
+
+				phyA := newPHY()
+				So(phyA.EncryptFRMPayloadWithProvider(provider, keys.AppSKey), ShouldBeNil)
+				So(phyA.SetMICWithProvider(provider, keys.FNwkSIntKey, append(opts, WithSNwkSIntKeyHandle(keys.SNwkSIntKey))...), ShouldBeNil)
+
+				phyB := newPHY()
+				So(phyB.EncryptFRMPayload(provider.keys[keys.AppSKey.(string)]), ShouldBeNil)
+				So(phyB.SetMIC(provider.keys[keys.FNwkSIntKey.(string)], append(opts, WithSNwkSIntKey(provider.keys[keys.SNwkSIntKey.(string)]))...), ShouldBeNil)
+
+				So(phyA, ShouldResemble, phyB)
+
+				ok, err := phyA.ValidateMICWithProvider(provider, keys.FNwkSIntKey, append(opts, WithSNwkSIntKeyHandle(keys.SNwkSIntKey))...)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("Given a join-accept", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: JoinAccept,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &JoinAcceptPayload{
+					AppNonce: [3]byte{1, 2, 3},
+					NetID:    [3]byte{4, 5, 6},
+					DevAddr:  DevAddr{1, 2, 3, 4},
+					RXDelay:  1,
+				},
+			}
+
+			Convey("Then encrypting and decrypting with the provider results in the same join-accept", func() {
+				So(phy.SetMICWithProvider(provider, "nwk-key"), ShouldBeNil)
+				expected := phy
+
+				So(phy.EncryptJoinAcceptPayloadWithProvider(provider, "nwk-key"), ShouldBeNil)
+				So(phy.DecryptJoinAcceptPayloadWithProvider(provider, "nwk-key"), ShouldBeNil)
+				So(phy.MACPayload, ShouldResemble, expected.MACPayload)
+				So(phy.MIC, ShouldEqual, expected.MIC)
+
+				ok, err := phy.ValidateMIC(rootKeys.NwkKey)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Then using an unknown key label returns an error", func() {
+				So(phy.SetMICWithProvider(provider, "unknown-key"), ShouldResemble, errors.New("unknown key unknown-key"))
+			})
+		})
+	})
+}
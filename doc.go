@@ -17,10 +17,13 @@ The Following message types (MType) are implemented:
     * UnconfirmedDataDown
     * ConfirmedDataUp
     * ConfirmedDataDown
-    * Proprietary
+    * RejoinRequest (LoRaWAN 1.1)
+    * Proprietary (see RegisterProprietaryHandler)
 
 The following MAC commands (and their optional payloads) are implemented:
 
+    * ResetInd (LoRaWAN 1.1)
+    * ResetConf (LoRaWAN 1.1)
     * LinkCheckReq
     * LinkCheckAns
     * LinkADRReq
@@ -35,9 +38,102 @@ The following MAC commands (and their optional payloads) are implemented:
     * NewChannelAns
     * RXTimingSetupReq
     * RXTimingSetupAns
-    * Support for proprietary commands (0x80 - 0xFF) will be implemented in the
-      future (mapping between CID and payload (size) is already done in a map
-      called macPayloadRegistry)
+    * TXParamSetupReq (LoRaWAN 1.0.2)
+    * TXParamSetupAns (LoRaWAN 1.0.2)
+    * DLChannelReq (LoRaWAN 1.0.2)
+    * DLChannelAns (LoRaWAN 1.0.2)
+    * RekeyInd (LoRaWAN 1.1)
+    * RekeyConf (LoRaWAN 1.1)
+    * ADRParamSetupReq (LoRaWAN 1.1)
+    * ADRParamSetupAns (LoRaWAN 1.1)
+    * DeviceTimeReq (LoRaWAN 1.0.3)
+    * DeviceTimeAns (LoRaWAN 1.0.3, see also TimeToGPSEpoch and GPSEpochToTime)
+    * ForceRejoinReq (LoRaWAN 1.1)
+    * RejoinParamSetupReq (LoRaWAN 1.1)
+    * RejoinParamSetupAns (LoRaWAN 1.1)
+    * PingSlotInfoReq (Class B)
+    * PingSlotInfoAns (Class B)
+    * PingSlotChannelReq (Class B)
+    * PingSlotChannelAns (Class B)
+    * BeaconTimingReq (Class B, removed since LoRaWAN 1.0.3)
+    * BeaconTimingAns (Class B, removed since LoRaWAN 1.0.3)
+    * BeaconFreqReq (Class B)
+    * BeaconFreqAns (Class B)
+    * Proprietary commands (0x80 - 0xFF) can be registered by calling
+      RegisterMACCommand()
+
+The Frequency of the PingSlotChannelReq and BeaconFreqReq is in units of
+100 Hz, 0 selects the default frequency. Any 24 bit Frequency is decoded, so
+that the device can answer with a NACK. Validate() checks it against the
+frequency range of the band:
+
+    err := pl.Validate(minFrequency, maxFrequency)
+
+The MAC commands are decoded using the MAC command registry of the
+DefaultCodec. To use a different set of MAC commands (e.g. for multiple
+network servers within the same process), create a Codec:
+
+    codec := lorawan.NewCodec(lorawan.WithMACCommand(true, 0x80, 2, newPayload))
+    err := codec.UnmarshalPHYPayload(&phyPayload, bytes)
+
+By default, a codec decodes all MAC commands and fields. When the LoRaWAN
+MAC version of the device is known, create the codec with the
+WithCodecMACVersion option (LoRaWAN1_0_0 - LoRaWAN1_0_4 or LoRaWAN1_1). MAC
+commands that are not defined by this version (e.g. BeaconTimingReq since
+LoRaWAN 1.0.3) are handled as unknown MAC commands, the join-accept OptNeg
+bit is only decoded for LoRaWAN 1.1 and the MIC of the decoded PHYPayload is
+calculated for this version by default.
+MarshalPHYPayload returns an error when the PHYPayload contains fields that
+are not defined by this version:
+
+    codec := lorawan.NewCodec(lorawan.WithCodecMACVersion(lorawan.LoRaWAN1_0_3))
+    b, err := codec.MarshalPHYPayload(phyPayload)
+
+As the size of an unknown MAC command is not known, decoding stops at the
+first unknown MAC command. Its remaining bytes are stored as
+UnknownMACCommands payload, so that they can be logged and are re-marshaled
+as-is. A codec created with the WithStrictMACCommands option returns an
+error instead.
+
+When decoding fails, a *DecodeError is returned containing the path of the
+field (e.g. PHYPayload.MACPayload.FHDR.FOpts[2]) and its byte offset. The
+cause can be tested using errors.Is (e.g. ErrTooShort, ErrInvalidFOptsLen,
+ErrFPortZeroWithFOpts, ErrUnknownMACCommand or ErrValueOutOfRange):
+
+    var de *lorawan.DecodeError
+    if errors.As(err, &de) && errors.Is(err, lorawan.ErrTooShort) {
+        log.Printf("frame truncated at %s (offset %d)", de.Path, de.Offset)
+    }
+
+The PHYPayload can be encoded into JSON for logging, storing and replaying
+frames in readable form. The JSON object contains the type of the
+(FRM)Payload and the names of the MAC commands, so that it can be decoded
+again by UnmarshalJSON. UnmarshalJSON also accepts the base64 string as
+returned by MarshalText:
+
+    b, err := json.Marshal(phyPayload)
+    err = json.Unmarshal(b, &phyPayload)
+
+UnmarshalJSON looks up the MAC command payloads in the DefaultCodec. A frame
+containing MAC commands which are only registered with an other codec must be
+decoded by this codec, an error is returned otherwise. Unknown MAC commands
+are encoded with the CID in hex format and "unknown": true:
+
+    err = codec.UnmarshalPHYPayloadJSON(&phyPayload, b)
+
+To avoid allocations when encoding frames, AppendBinary appends the
+PHYPayload (or MACPayload, FHDR, MACCommand and the join payloads) in binary
+form to a given buffer, which can be re-used. When decoding, a codec created
+with WithAliasedDataPayload() does not copy the frame and the DataPayload
+bytes, but lets them refer to the decoded slice. The caller must then not
+modify or re-use this slice (e.g. a receive buffer) while the PHYPayload is in
+use, and must validate the MIC before decrypting the FRMPayload in-place:
+
+    buf := make([]byte, 0, 256)
+    buf, err = phyPayload.AppendBinary(buf[:0])
+
+    codec := lorawan.NewCodec(lorawan.WithAliasedDataPayload())
+    err = codec.UnmarshalPHYPayload(&phyPayload, buf)
 
 Support for calculating and setting the MIC is done by calling SetMIC():
 
@@ -47,6 +143,55 @@ Validating the MIC is done by calling ValidateMIC():
 
     valid, err := phyPayload.ValidateMIC(key)
 
+RFU bits are stored in the RFU fields of the decoded structs (e.g. MHDR,
+Redundancy and the MAC command payloads), so that a decoded PHYPayload is
+re-marshaled into the received bytes. The last byte of the join-accept CFList
+is stored in CFListType. For uplink frames, bit 4 of the FCtrl is decoded as
+ClassB (or as RFU bit for a LoRaWAN 1.0.0 or 1.0.1 codec), for downlink
+frames as FPending.
+
+When the PHYPayload was decoded from binary form, the MIC is validated over
+the received bytes, so that RFU bits or unknown MAC commands do not
+invalidate the MIC. Call Remarshal() after modifying a decoded PHYPayload
+to validate the MIC over the modified fields. SetMIC() always uses the
+fields.
+
+As only the 16 least-significant bits of the frame-counter are sent, the
+full 32 bit frame-counter must be known to validate the MIC. ValidateMICFCnt()
+reconstructs it from the next expected frame-counter, validates the MIC and
+returns ErrFCntReplay or ErrFCntGap in case of a replayed frame or a gap of
+more than MaxFCntGap:
+
+    fCnt, valid, err := phyPayload.ValidateMICFCnt(key, expectedFCnt, band.MaxFCntGap)
+
+A replay of a frame of the previous 16 bit epoch also returns ErrFCntReplay.
+ValidateMICFCntWithProvider() and ValidateMICFCntWithContext() take a
+KeyProvider or CipherContext instead of the key.
+
+For LoRaWAN 1.1 data frames, the MAC version and the additional MIC parameters
+are passed as options. For uplink frames, the key is the FNwkSIntKey, for
+downlink frames the SNwkSIntKey:
+
+    err := phyPayload.SetMIC(fNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKey(sNwkSIntKey), WithTxDR(dr), WithTxCh(ch))
+
+The MIC of a rejoin-request is calculated with the SNwkSIntKey (type 0 and 2)
+or with the JSIntKey (type 1).
+
+The MIC of proprietary frames is calculated by the MIC function of the
+ProprietaryHandler registered for the major version of the frame. The
+handler can also define the payload type and the direction, used when
+decoding a proprietary frame:
+
+    err := RegisterProprietaryHandler(LoRaWANR1, ProprietaryHandler{
+        IsUplink: isUplinkFunc,
+        Payload:  newPayloadFunc,
+        MIC:      micFunc,
+    })
+
+RegisterProprietaryHandler registers the handler with the DefaultCodec. To
+use different handlers within the same process, register them per Codec
+using WithProprietaryHandler or Codec.RegisterProprietaryHandler.
+
 Encryption and decryption of the MACPayload (for join-accept) is done by
 calling EncryptJoinAcceptPayload() and DecryptJoinAcceptPayload(). Note that you need to
 call SetMIC BEFORE encryption.
@@ -54,6 +199,14 @@ call SetMIC BEFORE encryption.
     err := phyPayload.EncryptJoinAcceptPayload(key)
     err := phyPayload.DecryptJoinAcceptPayload(key)
 
+When the OptNeg bit of the DLSettings is set and the MAC version is LoRaWAN
+1.1 (by WithMACVersion or the MAC version of the codec), the join-accept MIC
+is calculated with the JSIntKey and covers the JoinReqType, JoinEUI and
+DevNonce of the request being answered. The join-accept is encrypted with
+the NwkKey (join-request) or the JSEncKey (rejoin-request):
+
+    err := phyPayload.SetMIC(jsIntKey, WithMACVersion(LoRaWAN1_1), WithJoinReqType(JoinRequestType, joinEUI, devNonce))
+
 Encryption and decryption of the FRMPayload is done by calling
 EncryptFRMPayload() and DecryptFRMPayload(). After encryption (and thus
 before decryption), the bytes are stored in the DataPayload struct.
@@ -61,6 +214,75 @@ before decryption), the bytes are stored in the DataPayload struct.
     err := phyPayload.EncryptFRMPayload(key)
     err := phyPayload.DecryptFRMPayload(key)
 
+For LoRaWAN 1.1 frames, the FOpts are encrypted with the NwkSEncKey. Use
+UnmarshalBinaryRawFOpts() to decode a frame without decoding the (encrypted)
+FOpts. Encryption and decryption of the FOpts is done by calling
+EncryptFOpts() and DecryptFOpts():
+
+    err := phyPayload.EncryptFOpts(nwkSEncKey)
+    err := phyPayload.DecryptFOpts(nwkSEncKey)
+
+Deriving the NwkSKey and AppSKey after an over-the-air activation is done by
+calling DeriveSessionKeys() with the join-request and decrypted join-accept
+payloads:
+
+    nwkSKey, appSKey, err := DeriveSessionKeys(appKey, jrPayload, jaPayload)
+
+For LoRaWAN 1.1 devices, the FNwkSIntKey, SNwkSIntKey, NwkSEncKey and AppSKey
+are derived from the NwkKey and AppKey root keys by calling
+DeriveLoRaWAN11SessionKeys(). The JSIntKey and JSEncKey are derived by calling
+DeriveJSIntKey() and DeriveJSEncKey().
+
+    sessionKeys, err := DeriveLoRaWAN11SessionKeys(rootKeys, joinNonce, joinEUI, devNonce)
+
+The derived SessionKeys can be used directly for the MIC and FRMPayload
+encryption of data frames, the matching key is selected based on the
+direction and FPort of the frame:
+
+    err := phyPayload.SetMICWithSessionKeys(sessionKeys, WithMACVersion(LoRaWAN1_1), WithTxDR(dr), WithTxCh(ch))
+    err := phyPayload.EncryptFRMPayloadWithSessionKeys(sessionKeys)
+
+The multicast group keys (LoRaWAN Remote Multicast Setup) are derived by
+calling DeriveMcRootKey(), DeriveMcKEKey(), DeriveMcAppSKey() and
+DeriveMcNwkSKey(). A MulticastSession keeps the McAddr, session keys and
+downlink frame-counter of a multicast group and returns encrypted and
+MIC-signed downlink frames:
+
+    mcSession, err := NewMulticastSession(mcKey, mcAddr, fCnt)
+    phyPayload, err := mcSession.NewDownlink(fPort, data)
+
+For high frame rates, a CipherContext can be created once per key and
+re-used for calculating the MIC and encrypting the FRMPayload of data
+frames without allocations:
+
+    ctx, err := NewCipherContext(key)
+    err := phyPayload.SetMICWithContext(ctx)
+    err := phyPayload.EncryptFRMPayloadWithContext(ctx)
+
+A CipherContext is software-only, it holds the key in memory. For the
+LoRaWAN 1.1 uplink MIC, pass the context of the SNwkSIntKey using
+WithSNwkSIntKeyHandle(sNwkSIntKeyCtx).
+
+For transporting session keys between the join server, network server and
+application server, keys can be wrapped with a KEK (RFC 3394) into a
+KeyEnvelope, which can be marshaled to JSON:
+
+    keyEnvelope, err := NewKeyEnvelope(kekLabel, kek, appSKey)
+    appSKey, err := keyEnvelope.Unwrap(kek)
+
+All cryptographic operations are implemented by a KeyProvider. The methods
+and functions taking an AES128Key use the in-memory SoftwareKeyProvider.
+To keep the keys within a secure module (e.g. a HSM), implement the
+KeyProvider interface and use the ...WithProvider variants, passing a
+KeyHandle instead of the key itself. The KeyProvider only exposes purpose
+specific operations (CMAC, join-accept encryption, FRMPayload and FOpts key
+streams and key derivation into a new handle), so that an implementation
+can restrict the operations per key and derived keys never leave the
+module:
+
+    err := phyPayload.SetMICWithProvider(provider, keyHandle)
+    keyHandles, err := DeriveLoRaWAN11SessionKeysWithProvider(provider, nwkKeyHandle, appKeyHandle, joinNonce, joinEUI, devNonce)
+
 All payloads implement the Payload interface. Based on the MIC value, you
 should be able to know to which type to cast the Payload value, so you will
 be able to access its fields.
@@ -0,0 +1,90 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Errors returned (wrapped) when decoding fails. Use errors.Is to test for
+// these and errors.As to retrieve the DecodeError.
+var (
+	ErrTooShort           = errors.New("lorawan: not enough bytes")
+	ErrInvalidLength      = errors.New("lorawan: invalid number of bytes")
+	ErrInvalidFOptsLen    = errors.New("lorawan: FOptsLen exceeds the number of bytes")
+	ErrFPortZeroWithFOpts = errors.New("lorawan: FPort must not be 0 when FOpts are set")
+	ErrUnknownMACCommand  = errors.New("lorawan: unknown MAC command")
+	ErrValueOutOfRange    = errors.New("lorawan: value out of range")
+)
+
+// DecodeError is returned when decoding fails. It contains the path of
+// the field (e.g. PHYPayload.MACPayload.FHDR.FOpts[2]) and the offset of
+// this field within the decoded bytes.
+type DecodeError struct {
+	Path   string
+	Offset int
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s (%s at offset %d)", e.Err, e.Path, e.Offset)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// wrapDecodeError prefixes the path of the given DecodeError with field and
+// adds offset to its offset. Any other error is returned as a DecodeError
+// for the given field and offset.
+func wrapDecodeError(field string, offset int, err error) error {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		return &DecodeError{Path: field, Offset: offset, Err: err}
+	}
+
+	path := de.Path
+	switch {
+	case field == "":
+	case path == "":
+		path = field
+	case path[0] == '[':
+		path = field + path
+	default:
+		path = field + "." + path
+	}
+
+	return &DecodeError{Path: path, Offset: offset + de.Offset, Err: de.Err}
+}
+
+// wrapDecodeIndexError prefixes the path of the given DecodeError with the
+// index ("[index]") of the list item, e.g. a MAC command. The index is only
+// formatted in case of an error.
+func wrapDecodeIndexError(index, offset int, err error) error {
+	return wrapDecodeError("["+strconv.Itoa(index)+"]", offset, err)
+}
+
+// sentinelError is an error with its own message, which matches the
+// sentinel error when using errors.Is.
+type sentinelError struct {
+	sentinel error
+	msg      string
+}
+
+// newError returns a new error with the given message, which wraps the
+// given sentinel error.
+func newError(sentinel error, format string, a ...interface{}) error {
+	return &sentinelError{sentinel: sentinel, msg: fmt.Sprintf(format, a...)}
+}
+
+// Error implements the error interface.
+func (e *sentinelError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the sentinel error.
+func (e *sentinelError) Unwrap() error {
+	return e.sentinel
+}
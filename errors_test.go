@@ -0,0 +1,92 @@
+package lorawan
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecodeError(t *testing.T) {
+	Convey("Given a set of malformed frames", t, func() {
+		testTable := []struct {
+			Name     string
+			Bytes    []byte
+			Sentinel error
+			Path     string
+			Offset   int
+			Error    string
+		}{
+			{
+				Name:     "too short",
+				Bytes:    []byte{0x40, 1, 2, 3},
+				Sentinel: ErrTooShort,
+				Path:     "PHYPayload",
+				Offset:   0,
+				Error:    "lorawan: at least 5 bytes needed to decode PHYPayload (PHYPayload at offset 0)",
+			},
+			{
+				Name:     "FOptsLen exceeds the number of bytes",
+				Bytes:    []byte{0x40, 4, 3, 2, 1, 0x05, 0, 0, 0x02, 1, 2, 3, 4},
+				Sentinel: ErrInvalidFOptsLen,
+				Path:     "PHYPayload.MACPayload.FHDR.FCtrl",
+				Offset:   5,
+				Error:    "lorawan: not enough bytes to decode FHDR (PHYPayload.MACPayload.FHDR.FCtrl at offset 5)",
+			},
+			{
+				Name:     "FPort 0 with FOpts",
+				Bytes:    []byte{0x40, 4, 3, 2, 1, 0x01, 0, 0, 0x02, 0, 1, 1, 2, 3, 4},
+				Sentinel: ErrFPortZeroWithFOpts,
+				Path:     "PHYPayload.MACPayload.FPort",
+				Offset:   9,
+				Error:    "lorawan: FPort must not be 0 when FOpts are set (PHYPayload.MACPayload.FPort at offset 9)",
+			},
+			{
+				Name:     "truncated MAC command in the FOpts",
+				Bytes:    []byte{0x40, 4, 3, 2, 1, 0x04, 0, 0, 0x02, 0x03, 0x07, 0x01, 1, 2, 3, 4},
+				Sentinel: ErrTooShort,
+				Path:     "PHYPayload.MACPayload.FHDR.FOpts[2]",
+				Offset:   11,
+				Error:    "lorawan: not enough remaining bytes (PHYPayload.MACPayload.FHDR.FOpts[2] at offset 11)",
+			},
+			{
+				Name:     "invalid RejoinType",
+				Bytes:    append([]byte{0xc0, 0x03}, make([]byte, 17)...),
+				Sentinel: ErrValueOutOfRange,
+				Path:     "PHYPayload.MACPayload",
+				Offset:   1,
+				Error:    "lorawan: RejoinType must be 0 or 2 (PHYPayload.MACPayload at offset 1)",
+			},
+		}
+
+		for _, test := range testTable {
+			Convey("Testing: "+test.Name, func() {
+				var phy PHYPayload
+				err := phy.UnmarshalBinary(test.Bytes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, test.Error)
+				So(errors.Is(err, test.Sentinel), ShouldBeTrue)
+
+				var de *DecodeError
+				So(errors.As(err, &de), ShouldBeTrue)
+				So(de.Path, ShouldEqual, test.Path)
+				So(de.Offset, ShouldEqual, test.Offset)
+			})
+		}
+	})
+
+	Convey("Given a FRMPayload (FPort=0) with an unknown MAC command", t, func() {
+		b := []byte{0x40, 4, 3, 2, 1, 0, 0, 0, 0, 0x02, 0x7f, 1, 2, 3, 4}
+
+		Convey("Then a strict codec returns an ErrUnknownMACCommand DecodeError with the path and offset", func() {
+			var phy PHYPayload
+			err := NewCodec(WithStrictMACCommands()).UnmarshalPHYPayload(&phy, b)
+			So(errors.Is(err, ErrUnknownMACCommand), ShouldBeTrue)
+			So(err, ShouldResemble, &DecodeError{
+				Path:   "PHYPayload.MACPayload.FRMPayload[1]",
+				Offset: 10,
+				Err:    newError(ErrUnknownMACCommand, "lorawan: unknown MAC command with CID=127"),
+			})
+		})
+	})
+}
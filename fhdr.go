@@ -28,7 +28,7 @@ func (a DevAddr) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (a *DevAddr) UnmarshalBinary(data []byte) error {
 	if len(data) != len(a) {
-		return fmt.Errorf("lorawan: %d bytes of data are expected", len(a))
+		return newError(ErrInvalidLength, "lorawan: %d bytes of data are expected", len(a))
 	}
 	for i, v := range data {
 		// little endian
@@ -79,17 +79,24 @@ type FCtrl struct {
 	ADR       bool
 	ADRACKReq bool
 	ACK       bool
+	ClassB    bool  // only used for uplink messages, since LoRaWAN 1.0.2
 	FPending  bool  // only used for downlink messages
+	RFU       bool  // RFU bit (bit 4) of LoRaWAN 1.0.0 and 1.0.1 uplinks as received, preserved when re-marshaling
 	fOptsLen  uint8 // will be set automatically by the FHDR when serialized to []byte
 }
 
 // MarshalBinary marshals the object in binary form.
 func (c FCtrl) MarshalBinary() ([]byte, error) {
+	return c.appendBinary(make([]byte, 0, 1))
+}
+
+// appendBinary appends the object in binary form to b.
+func (c FCtrl) appendBinary(out []byte) ([]byte, error) {
 	if c.fOptsLen > 15 {
-		return []byte{}, errors.New("lorawan: max value of FOptsLen is 15")
+		return out, errors.New("lorawan: max value of FOptsLen is 15")
 	}
 	b := byte(c.fOptsLen)
-	if c.FPending {
+	if c.FPending || c.ClassB || c.RFU {
 		b = b ^ (1 << 4)
 	}
 	if c.ACK {
@@ -101,13 +108,16 @@ func (c FCtrl) MarshalBinary() ([]byte, error) {
 	if c.ADR {
 		b = b ^ (1 << 7)
 	}
-	return []byte{b}, nil
+	return append(out, b), nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// UnmarshalBinary decodes the object from binary form. As the direction
+// is not known, bit 4 is decoded as FPending. The FHDR decodes it as ClassB
+// for uplink messages, or as RFU when the MAC version of the codec is
+// LoRaWAN 1.0.0 or 1.0.1.
 func (c *FCtrl) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	c.fOptsLen = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
 	c.FPending = data[0]&(1<<4) > 0
@@ -119,88 +129,129 @@ func (c *FCtrl) UnmarshalBinary(data []byte) error {
 
 // FHDR represents the frame header.
 type FHDR struct {
-	DevAddr DevAddr
-	FCtrl   FCtrl
-	FCnt    uint32       // only the least-significant 16 bits will be marshalled
-	FOpts   []MACCommand // max. number of allowed bytes is 15
+	DevAddr  DevAddr
+	FCtrl    FCtrl
+	FCnt     uint32       // only the least-significant 16 bits will be marshalled
+	FOpts    []MACCommand // max. number of allowed bytes is 15
+	RawFOpts []byte       // FOpts which are not (yet) decoded, e.g. because they are encrypted
 }
 
-// MarshalBinary marshals the object in binary form.
-func (h FHDR) MarshalBinary() ([]byte, error) {
-	var b []byte
-	var err error
-	var opts []byte
+// marshalFOpts returns the FOpts in binary form.
+func (h FHDR) marshalFOpts() ([]byte, error) {
+	if len(h.RawFOpts) != 0 {
+		if len(h.FOpts) != 0 {
+			return nil, errors.New("lorawan: FOpts and RawFOpts can not be set at the same time")
+		}
+		return h.RawFOpts, nil
+	}
 
+	var opts []byte
 	for _, mac := range h.FOpts {
-		b, err = mac.MarshalBinary()
+		b, err := mac.MarshalBinary()
 		if err != nil {
-			return []byte{}, err
+			return nil, err
 		}
 		opts = append(opts, b...)
 	}
-	h.FCtrl.fOptsLen = uint8(len(opts))
-	if h.FCtrl.fOptsLen > 15 {
-		return []byte{}, errors.New("lorawan: max number of FOpts bytes is 15")
-	}
+	return opts, nil
+}
 
-	out := make([]byte, 0, 7+h.FCtrl.fOptsLen)
-	b, err = h.DevAddr.MarshalBinary()
+// unmarshalFOpts decodes the given FOpts bytes into MAC commands.
+func (h *FHDR) unmarshalFOpts(codec *Codec, uplink bool, data []byte) error {
+	macs, err := codec.unmarshalMACCommands(uplink, data)
 	if err != nil {
-		return []byte{}, err
+		return wrapDecodeError("FOpts", 0, err)
 	}
-	out = append(out, b...)
+	h.FOpts = append(h.FOpts, macs...)
+	return nil
+}
 
-	b, err = h.FCtrl.MarshalBinary()
-	if err != nil {
-		return []byte{}, err
+// MarshalBinary marshals the object in binary form.
+func (h FHDR) MarshalBinary() ([]byte, error) {
+	return h.AppendBinary(make([]byte, 0, 7+15))
+}
+
+// AppendBinary appends the object in binary form to out. Unless the FOpts
+// contain MAC command payloads, this does not allocate when out has enough
+// capacity.
+func (h FHDR) AppendBinary(out []byte) ([]byte, error) {
+	if len(h.RawFOpts) != 0 && len(h.FOpts) != 0 {
+		return out, errors.New("lorawan: FOpts and RawFOpts can not be set at the same time")
+	}
+
+	start := len(out)
+
+	// little endian
+	out = append(out, h.DevAddr[3], h.DevAddr[2], h.DevAddr[1], h.DevAddr[0])
+
+	// the FCtrl is set after the FOpts, as it contains the FOptsLen
+	out = append(out, 0)
+	out = append(out, byte(h.FCnt), byte(h.FCnt>>8))
+
+	out = append(out, h.RawFOpts...)
+	for _, mac := range h.FOpts {
+		var err error
+		out, err = mac.AppendBinary(out)
+		if err != nil {
+			return out[:start], err
+		}
+	}
+
+	optsLen := len(out) - start - 7
+	if optsLen > 15 {
+		return out[:start], errors.New("lorawan: max number of FOpts bytes is 15")
+	}
+	h.FCtrl.fOptsLen = uint8(optsLen)
+
+	if _, err := h.FCtrl.appendBinary(out[start+4 : start+4]); err != nil {
+		return out[:start], err
 	}
-	out = append(out, b...)
-	fCntBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(fCntBytes, h.FCnt)
-	out = append(out, fCntBytes[0:2]...)
-	out = append(out, opts...)
 
 	return out, nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// UnmarshalBinary decodes the object from binary form. On error, a
+// *DecodeError is returned.
 func (h *FHDR) UnmarshalBinary(uplink bool, data []byte) error {
+	if err := h.unmarshalBinary(DefaultCodec, uplink, false, data); err != nil {
+		return wrapDecodeError("FHDR", 0, err)
+	}
+	return nil
+}
+
+// unmarshalBinary decodes the object from binary form. When rawFOpts is
+// set, the FOpts are stored as RawFOpts instead of being decoded.
+func (h *FHDR) unmarshalBinary(codec *Codec, uplink, rawFOpts bool, data []byte) error {
 	if len(data) < 7 {
-		return errors.New("lorawan: at least 7 bytes are expected")
+		return wrapDecodeError("", 0, newError(ErrTooShort, "lorawan: at least 7 bytes are expected"))
 	}
 
 	if err := h.DevAddr.UnmarshalBinary(data[0:4]); err != nil {
-		return err
+		return wrapDecodeError("DevAddr", 0, err)
 	}
 	if err := h.FCtrl.UnmarshalBinary(data[4:5]); err != nil {
-		return err
+		return wrapDecodeError("FCtrl", 4, err)
+	}
+	if uplink {
+		if codec.macVersion != nil && *codec.macVersion < LoRaWAN1_0_2 {
+			h.FCtrl.RFU = h.FCtrl.FPending
+		} else {
+			h.FCtrl.ClassB = h.FCtrl.FPending
+		}
+		h.FCtrl.FPending = false
 	}
 	fCntBytes := make([]byte, 4)
 	copy(fCntBytes, data[5:7])
 	h.FCnt = binary.LittleEndian.Uint32(fCntBytes)
 
 	if len(data) > 7 {
-		var pLen int
-		for i := 0; i < len(data[7:]); i++ {
-			if _, s, err := getMACPayloadAndSize(uplink, cid(data[7+i])); err != nil {
-				pLen = 0
-			} else {
-				pLen = s
-			}
-
-			// check if the remaining bytes are >= CID byte + payload size
-			if len(data[7+i:]) < pLen+1 {
-				return errors.New("lorawan: not enough remaining bytes")
-			}
-
-			mc := MACCommand{}
-			if err := mc.UnmarshalBinary(uplink, data[7+i:7+i+1+pLen]); err != nil {
-				return err
-			}
-			h.FOpts = append(h.FOpts, mc)
-
-			// go to the next command (skip the payload bytes of the current command)
-			i = i + pLen
+		if rawFOpts {
+			h.RawFOpts = make([]byte, len(data[7:]))
+			copy(h.RawFOpts, data[7:])
+			return nil
+		}
+		if err := h.unmarshalFOpts(codec, uplink, data[7:]); err != nil {
+			return wrapDecodeError("", 7, err)
 		}
 	}
 
@@ -123,6 +123,30 @@ func TestFCtrl(t *testing.T) {
 			})
 		}
 	})
+
+	Convey("Given FHDR bytes with bit 4 of the FCtrl set", t, func() {
+		b := []byte{4, 3, 2, 1, 0x90, 0, 0}
+
+		Convey("Then it is decoded as ClassB for uplink", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(true, b), ShouldBeNil)
+			So(h.FCtrl, ShouldResemble, FCtrl{ADR: true, ClassB: true})
+
+			out, err := h.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, b)
+		})
+
+		Convey("Then it is decoded as FPending for downlink", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(false, b), ShouldBeNil)
+			So(h.FCtrl, ShouldResemble, FCtrl{ADR: true, FPending: true})
+
+			out, err := h.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, b)
+		})
+	})
 }
 
 func TestFHDR(t *testing.T) {
@@ -178,6 +202,32 @@ func TestFHDR(t *testing.T) {
 			})
 		})
 
+		Convey("Given RawFOpts=[]byte{2, 7, 9}", func() {
+			h.RawFOpts = []byte{2, 7, 9}
+			Convey("Then MarshalBinary returns []byte{0, 0, 0, 0, 3, 0, 0, 2, 7, 9}", func() {
+				b, err := h.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, []byte{0, 0, 0, 0, 3, 0, 0, 2, 7, 9})
+			})
+
+			Convey("Given FOpts are set too", func() {
+				h.FOpts = []MACCommand{{CID: LinkCheckReq}}
+				Convey("Then MarshalBinary returns an error", func() {
+					_, err := h.MarshalBinary()
+					So(err, ShouldResemble, errors.New("lorawan: FOpts and RawFOpts can not be set at the same time"))
+				})
+			})
+		})
+
+		Convey("Given uplink=false, rawFOpts=true and slice []byte{4, 3, 2, 1, 179, 5, 0, 2, 7, 9}", func() {
+			b := []byte{4, 3, 2, 1, 179, 5, 0, 2, 7, 9}
+			Convey("Then unmarshalBinary stores the FOpts as RawFOpts", func() {
+				So(h.unmarshalBinary(DefaultCodec, false, true, b), ShouldBeNil)
+				So(h.FOpts, ShouldHaveLength, 0)
+				So(h.RawFOpts, ShouldResemble, []byte{2, 7, 9})
+			})
+		})
+
 		Convey("Given uplink=false and slice []byte{4, 2, 2, 1, 179, 5, 0, 2, 7, 9}", func() {
 			b := []byte{4, 3, 2, 1, 179, 5, 0, 2, 7, 9}
 			Convey("Then UnmarshalBinary does not return an error", func() {
@@ -212,7 +262,7 @@ func TestFHDR(t *testing.T) {
 			b := []byte{1, 2, 3, 4, 179, 5, 0, 2, 7}
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := h.UnmarshalBinary(false, b)
-				So(err, ShouldResemble, errors.New("lorawan: not enough remaining bytes"))
+				So(err, ShouldResemble, &DecodeError{Path: "FHDR.FOpts[0]", Offset: 7, Err: newError(ErrTooShort, "lorawan: not enough remaining bytes")})
 			})
 		})
 	})
@@ -0,0 +1,55 @@
+package lorawan
+
+import "time"
+
+// gpsEpochTime contains the start of the GPS epoch.
+var gpsEpochTime = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// leapSecondsTable contains the (UTC) moments at which a leap second was
+// added since the start of the GPS epoch. It must be updated when a new
+// leap second is announced.
+var leapSecondsTable = []time.Time{
+	time.Date(1981, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1982, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1983, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1985, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1988, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1991, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1992, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1993, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1994, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1996, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1997, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1999, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2006, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2009, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2012, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2015, time.July, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// TimeToGPSEpoch returns the time since the GPS epoch
+// (1980-01-06T00:00:00Z) for the given time, including the leap seconds
+// added since.
+func TimeToGPSEpoch(t time.Time) time.Duration {
+	d := t.Sub(gpsEpochTime)
+	for _, ls := range leapSecondsTable {
+		if !t.Before(ls) {
+			d += time.Second
+		}
+	}
+	return d
+}
+
+// GPSEpochToTime returns the time for the given time since the GPS epoch,
+// corrected for the leap seconds added since.
+func GPSEpochToTime(d time.Duration) time.Time {
+	t := gpsEpochTime.Add(d)
+	for _, ls := range leapSecondsTable {
+		if !t.Before(ls) {
+			t = t.Add(-time.Second)
+		}
+	}
+	return t
+}
@@ -0,0 +1,34 @@
+package lorawan
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGPSEpoch(t *testing.T) {
+	Convey("Given a set of tests", t, func() {
+		testTable := []struct {
+			Time              time.Time
+			TimeSinceGPSEpoch time.Duration
+		}{
+			{gpsEpochTime, 0},
+			{time.Date(1981, time.June, 30, 23, 59, 59, 0, time.UTC), 46828799 * time.Second},
+			{time.Date(1981, time.July, 1, 0, 0, 0, 0, time.UTC), 46828801 * time.Second},
+			{time.Date(2010, time.January, 28, 16, 36, 24, 0, time.UTC), 948731799 * time.Second},
+			{time.Date(2025, time.May, 1, 12, 0, 0, 500000000, time.UTC), 1430136018*time.Second + 500*time.Millisecond},
+		}
+
+		for _, test := range testTable {
+			Convey(fmt.Sprintf("Then TimeToGPSEpoch returns %s for %s", test.TimeSinceGPSEpoch, test.Time), func() {
+				So(TimeToGPSEpoch(test.Time), ShouldEqual, test.TimeSinceGPSEpoch)
+			})
+
+			Convey(fmt.Sprintf("Then GPSEpochToTime returns %s for %s", test.Time, test.TimeSinceGPSEpoch), func() {
+				So(GPSEpochToTime(test.TimeSinceGPSEpoch).Equal(test.Time), ShouldBeTrue)
+			})
+		}
+	})
+}
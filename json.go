@@ -0,0 +1,489 @@
+package lorawan
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// macCommandNames contains the names of the uplink and downlink MAC
+// commands in the format map[uplink]map[CID].
+var macCommandNames = map[bool]map[CID]string{
+	false: {
+		ResetConf:           "ResetConf",
+		LinkCheckAns:        "LinkCheckAns",
+		LinkADRReq:          "LinkADRReq",
+		DutyCycleReq:        "DutyCycleReq",
+		RXParamSetupReq:     "RXParamSetupReq",
+		DevStatusReq:        "DevStatusReq",
+		NewChannelReq:       "NewChannelReq",
+		RXTimingSetupReq:    "RXTimingSetupReq",
+		TXParamSetupReq:     "TXParamSetupReq",
+		DLChannelReq:        "DLChannelReq",
+		RekeyConf:           "RekeyConf",
+		ADRParamSetupReq:    "ADRParamSetupReq",
+		DeviceTimeAns:       "DeviceTimeAns",
+		ForceRejoinReq:      "ForceRejoinReq",
+		RejoinParamSetupReq: "RejoinParamSetupReq",
+		PingSlotInfoAns:     "PingSlotInfoAns",
+		PingSlotChannelReq:  "PingSlotChannelReq",
+		BeaconTimingAns:     "BeaconTimingAns",
+		BeaconFreqReq:       "BeaconFreqReq",
+	},
+	true: {
+		ResetInd:            "ResetInd",
+		LinkCheckReq:        "LinkCheckReq",
+		LinkADRAns:          "LinkADRAns",
+		DutyCycleAns:        "DutyCycleAns",
+		RXParamSetupAns:     "RXParamSetupAns",
+		DevStatusAns:        "DevStatusAns",
+		NewChannelAns:       "NewChannelAns",
+		RXTimingSetupAns:    "RXTimingSetupAns",
+		TXParamSetupAns:     "TXParamSetupAns",
+		DLChannelAns:        "DLChannelAns",
+		RekeyInd:            "RekeyInd",
+		ADRParamSetupAns:    "ADRParamSetupAns",
+		DeviceTimeReq:       "DeviceTimeReq",
+		RejoinParamSetupAns: "RejoinParamSetupAns",
+		PingSlotInfoReq:     "PingSlotInfoReq",
+		PingSlotChannelAns:  "PingSlotChannelAns",
+		BeaconTimingReq:     "BeaconTimingReq",
+		BeaconFreqAns:       "BeaconFreqAns",
+	},
+}
+
+// cidName returns the name of the MAC command, or the CID in hex format
+// (e.g. 0x80) in case of an unknown or proprietary MAC command.
+func cidName(uplink bool, cid CID) string {
+	if name, ok := macCommandNames[uplink][cid]; ok {
+		return name
+	}
+	return cidHex(cid)
+}
+
+// cidHex returns the CID in hex format (e.g. 0x80).
+func cidHex(cid CID) string {
+	return fmt.Sprintf("0x%02x", byte(cid))
+}
+
+// parseCIDName returns the CID for the given MAC command name (see
+// cidName).
+func parseCIDName(uplink bool, name string) (CID, error) {
+	for cid, n := range macCommandNames[uplink] {
+		if n == name {
+			return cid, nil
+		}
+	}
+
+	v, err := strconv.ParseUint(name, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("lorawan: unknown MAC command %s for uplink=%v", name, uplink)
+	}
+	return CID(v), nil
+}
+
+// Payload type names used by the JSON representation of a PHYPayload.
+const (
+	jsonTypeMACPayload                 = "MACPayload"
+	jsonTypeDataPayload                = "DataPayload"
+	jsonTypeMACCommand                 = "MACCommand"
+	jsonTypeJoinRequestPayload         = "JoinRequestPayload"
+	jsonTypeJoinAcceptPayload          = "JoinAcceptPayload"
+	jsonTypeRejoinRequestType02Payload = "RejoinRequestType02Payload"
+	jsonTypeRejoinRequestType1Payload  = "RejoinRequestType1Payload"
+	jsonTypeBinary                     = "Binary"
+)
+
+type phyPayloadJSON struct {
+	MHDR       mhdrJSON    `json:"mhdr"`
+	MACPayload payloadJSON `json:"macPayload"`
+	MIC        HEXBytes    `json:"mic"`
+}
+
+type mhdrJSON struct {
+	MType string `json:"mType"`
+	Major Major  `json:"major"`
+	RFU   uint8  `json:"rfu,omitempty"`
+}
+
+// payloadJSON holds a Payload, of which the type is set as discriminator.
+type payloadJSON struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type macPayloadJSON struct {
+	FHDR       fhdrJSON      `json:"fhdr"`
+	FPort      *uint8        `json:"fPort,omitempty"`
+	FRMPayload []payloadJSON `json:"frmPayload,omitempty"`
+}
+
+type fhdrJSON struct {
+	DevAddr  DevAddr          `json:"devAddr"`
+	FCtrl    FCtrl            `json:"fCtrl"`
+	FCnt     uint32           `json:"fCnt"`
+	FOpts    []macCommandJSON `json:"fOpts,omitempty"`
+	RawFOpts HEXBytes         `json:"rawFOpts,omitempty"`
+}
+
+// macCommandJSON holds a MAC command. For UnknownMACCommands, the CID is
+// always in hex format and Unknown is set.
+type macCommandJSON struct {
+	CID     string          `json:"cid"`
+	Unknown bool            `json:"unknown,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// MarshalJSON encodes the UnknownMACCommands into JSON, with the bytes in
+// hex format.
+func (p UnknownMACCommands) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Bytes HEXBytes }{p.Bytes})
+}
+
+// UnmarshalJSON decodes the UnknownMACCommands from JSON.
+func (p *UnknownMACCommands) UnmarshalJSON(data []byte) error {
+	var v struct{ Bytes HEXBytes }
+	if err := unmarshalJSONStrict(data, &v); err != nil {
+		return err
+	}
+	p.Bytes = []byte(v.Bytes)
+	return nil
+}
+
+// unmarshalJSONStrict decodes the JSON data into v and returns an error
+// when the data contains fields which are not defined by v.
+func unmarshalJSONStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// MarshalJSON encodes the PHYPayload into JSON. Unlike MarshalText, the
+// fields are encoded in readable form, including the type of the
+// MACPayload, FRMPayload and the names of the MAC commands so that it can
+// be decoded again using UnmarshalJSON.
+func (p PHYPayload) MarshalJSON() ([]byte, error) {
+	out := phyPayloadJSON{
+		MHDR: mhdrJSON{
+			MType: p.MHDR.MType.String(),
+			Major: p.MHDR.Major,
+			RFU:   p.MHDR.RFU,
+		},
+		MIC: HEXBytes(p.MIC[:]),
+	}
+
+	if p.MACPayload == nil {
+		return nil, fmt.Errorf("lorawan: MACPayload should not be nil")
+	}
+
+	var err error
+	out.MACPayload, err = marshalPayloadJSON(p.isUplink(), p.MACPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the PHYPayload from JSON, as encoded by
+// MarshalJSON, using the DefaultCodec. For backwards compatibility, a JSON
+// string containing the base64 encoded PHYPayload (see MarshalText) is
+// accepted too. Use Codec.UnmarshalPHYPayloadJSON for frames containing MAC
+// commands that are only registered with an other codec.
+func (p *PHYPayload) UnmarshalJSON(data []byte) error {
+	return p.unmarshalJSON(nil, data)
+}
+
+// unmarshalJSON decodes the PHYPayload from JSON, the MAC command payloads
+// are looked up in the registry of the given codec (nil = DefaultCodec).
+func (p *PHYPayload) unmarshalJSON(codec *Codec, data []byte) error {
+	if len(data) != 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if codec == nil {
+			return p.UnmarshalText([]byte(s))
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		return codec.UnmarshalPHYPayload(p, b)
+	}
+
+	var in phyPayloadJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	mType, err := parseMType(in.MHDR.MType)
+	if err != nil {
+		return err
+	}
+
+	if len(in.MIC) != len(p.MIC) {
+		return fmt.Errorf("lorawan: a MIC of %d bytes is expected", len(p.MIC))
+	}
+
+	*p = PHYPayload{
+		MHDR: MHDR{
+			MType: mType,
+			Major: in.MHDR.Major,
+			RFU:   in.MHDR.RFU,
+		},
+		codec: codec,
+	}
+	copy(p.MIC[:], in.MIC)
+
+	p.MACPayload, err = p.unmarshalPayloadJSON(p.isUplink(), in.MACPayload)
+	return err
+}
+
+// parseMType returns the MType for the given name (see MType.String).
+func parseMType(s string) (MType, error) {
+	for m := JoinRequest; m <= Proprietary; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("lorawan: unknown MType %s", s)
+}
+
+func marshalPayloadJSON(uplink bool, pl Payload) (payloadJSON, error) {
+	var out payloadJSON
+	var v interface{}
+
+	switch pl := pl.(type) {
+	case *MACPayload:
+		mpl, err := marshalMACPayloadJSON(uplink, pl)
+		if err != nil {
+			return out, err
+		}
+		out.Type, v = jsonTypeMACPayload, mpl
+	case *DataPayload:
+		out.Type, v = jsonTypeDataPayload, HEXBytes(pl.Bytes)
+	case *MACCommand:
+		mac, err := marshalMACCommandJSON(uplink, *pl)
+		if err != nil {
+			return out, err
+		}
+		out.Type, v = jsonTypeMACCommand, mac
+	case *JoinRequestPayload:
+		out.Type, v = jsonTypeJoinRequestPayload, pl
+	case *JoinAcceptPayload:
+		out.Type, v = jsonTypeJoinAcceptPayload, pl
+	case *RejoinRequestType02Payload:
+		out.Type, v = jsonTypeRejoinRequestType02Payload, pl
+	case *RejoinRequestType1Payload:
+		out.Type, v = jsonTypeRejoinRequestType1Payload, pl
+	default:
+		// e.g. the payload of a proprietary frame
+		b, err := pl.MarshalBinary()
+		if err != nil {
+			return out, err
+		}
+		out.Type, v = jsonTypeBinary, HEXBytes(b)
+	}
+
+	var err error
+	out.Value, err = json.Marshal(v)
+	return out, err
+}
+
+func (p *PHYPayload) unmarshalPayloadJSON(uplink bool, in payloadJSON) (Payload, error) {
+	var pl Payload
+
+	switch in.Type {
+	case jsonTypeMACPayload:
+		return p.unmarshalMACPayloadJSON(uplink, in.Value)
+	case jsonTypeDataPayload:
+		var b HEXBytes
+		if err := json.Unmarshal(in.Value, &b); err != nil {
+			return nil, err
+		}
+		return &DataPayload{Bytes: b}, nil
+	case jsonTypeMACCommand:
+		var m macCommandJSON
+		if err := json.Unmarshal(in.Value, &m); err != nil {
+			return nil, err
+		}
+		mac, err := p.unmarshalMACCommandJSON(uplink, m)
+		if err != nil {
+			return nil, err
+		}
+		return &mac, nil
+	case jsonTypeJoinRequestPayload:
+		pl = &JoinRequestPayload{}
+	case jsonTypeJoinAcceptPayload:
+		pl = &JoinAcceptPayload{}
+	case jsonTypeRejoinRequestType02Payload:
+		pl = &RejoinRequestType02Payload{}
+	case jsonTypeRejoinRequestType1Payload:
+		pl = &RejoinRequestType1Payload{}
+	case jsonTypeBinary:
+		var b HEXBytes
+		if err := json.Unmarshal(in.Value, &b); err != nil {
+			return nil, err
+		}
+
+		pl = &DataPayload{}
+		if h, ok := p.getCodec().getProprietaryHandler(p.MHDR.Major); ok && p.MHDR.MType == Proprietary {
+			if h.Payload != nil {
+				pl = h.Payload()
+			}
+			if h.IsUplink != nil {
+				mhdr, err := p.MHDR.MarshalBinary()
+				if err != nil {
+					return nil, err
+				}
+				uplink = h.IsUplink(append(append(mhdr, b...), p.MIC[:]...))
+			}
+		}
+		if err := pl.UnmarshalBinary(uplink, b); err != nil {
+			return nil, err
+		}
+		return pl, nil
+	default:
+		return nil, fmt.Errorf("lorawan: unknown payload type %s", in.Type)
+	}
+
+	if err := json.Unmarshal(in.Value, pl); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+func marshalMACPayloadJSON(uplink bool, pl *MACPayload) (macPayloadJSON, error) {
+	out := macPayloadJSON{
+		FHDR: fhdrJSON{
+			DevAddr:  pl.FHDR.DevAddr,
+			FCtrl:    pl.FHDR.FCtrl,
+			FCnt:     pl.FHDR.FCnt,
+			RawFOpts: HEXBytes(pl.FHDR.RawFOpts),
+		},
+		FPort: pl.FPort,
+	}
+
+	for _, mac := range pl.FHDR.FOpts {
+		m, err := marshalMACCommandJSON(uplink, mac)
+		if err != nil {
+			return out, err
+		}
+		out.FHDR.FOpts = append(out.FHDR.FOpts, m)
+	}
+
+	for _, fp := range pl.FRMPayload {
+		f, err := marshalPayloadJSON(uplink, fp)
+		if err != nil {
+			return out, err
+		}
+		out.FRMPayload = append(out.FRMPayload, f)
+	}
+
+	return out, nil
+}
+
+func (p *PHYPayload) unmarshalMACPayloadJSON(uplink bool, data []byte) (*MACPayload, error) {
+	var in macPayloadJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+
+	pl := MACPayload{
+		FHDR: FHDR{
+			DevAddr: in.FHDR.DevAddr,
+			FCtrl:   in.FHDR.FCtrl,
+			FCnt:    in.FHDR.FCnt,
+		},
+		FPort: in.FPort,
+	}
+	if len(in.FHDR.RawFOpts) != 0 {
+		pl.FHDR.RawFOpts = []byte(in.FHDR.RawFOpts)
+	}
+
+	for _, m := range in.FHDR.FOpts {
+		mac, err := p.unmarshalMACCommandJSON(uplink, m)
+		if err != nil {
+			return nil, err
+		}
+		pl.FHDR.FOpts = append(pl.FHDR.FOpts, mac)
+	}
+
+	for _, f := range in.FRMPayload {
+		fp, err := p.unmarshalPayloadJSON(uplink, f)
+		if err != nil {
+			return nil, err
+		}
+		pl.FRMPayload = append(pl.FRMPayload, fp)
+	}
+
+	return &pl, nil
+}
+
+func marshalMACCommandJSON(uplink bool, mac MACCommand) (macCommandJSON, error) {
+	out := macCommandJSON{
+		CID: cidName(uplink, mac.CID),
+	}
+	if _, ok := mac.Payload.(*UnknownMACCommands); ok {
+		out.CID, out.Unknown = cidHex(mac.CID), true
+	}
+
+	if mac.Payload != nil {
+		b, err := json.Marshal(mac.Payload)
+		if err != nil {
+			return out, err
+		}
+		out.Payload = b
+	}
+
+	return out, nil
+}
+
+// unmarshalMACCommandJSON decodes the MAC command. The payload type is
+// looked up in the MAC command registry of the codec of the PHYPayload. An
+// error is returned when the MAC command is not registered with this codec
+// or the payload contains fields which are not defined by its type.
+func (p *PHYPayload) unmarshalMACCommandJSON(uplink bool, in macCommandJSON) (MACCommand, error) {
+	var mac MACCommand
+
+	cid, err := parseCIDName(uplink, in.CID)
+	if err != nil {
+		return mac, err
+	}
+	mac.CID = cid
+
+	noPayload := len(in.Payload) == 0 || bytes.Equal(in.Payload, []byte("null"))
+
+	if in.Unknown {
+		pl := &UnknownMACCommands{}
+		if !noPayload {
+			if err := json.Unmarshal(in.Payload, pl); err != nil {
+				return mac, err
+			}
+		}
+		mac.Payload = pl
+		return mac, nil
+	}
+
+	if noPayload {
+		return mac, nil
+	}
+
+	pl, _, err := p.getCodec().getMACPayloadAndSize(uplink, cid)
+	if err != nil {
+		return mac, fmt.Errorf("lorawan: MAC command %s is not registered with the codec", in.CID)
+	}
+	if pl == nil {
+		return mac, fmt.Errorf("lorawan: MAC command %s does not have a payload", in.CID)
+	}
+
+	if err := unmarshalJSONStrict(in.Payload, pl); err != nil {
+		return mac, err
+	}
+	mac.Payload = pl
+
+	return mac, nil
+}
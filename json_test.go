@@ -0,0 +1,226 @@
+package lorawan
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPHYPayloadJSON(t *testing.T) {
+	Convey("Given a set of PHYPayloads", t, func() {
+		fPort0 := uint8(0)
+		fPort10 := uint8(10)
+
+		testTable := []struct {
+			Name       string
+			PHYPayload PHYPayload
+		}{
+			{
+				Name: "uplink with FOpts and FRMPayload",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+					MACPayload: &MACPayload{
+						FHDR: FHDR{
+							DevAddr: DevAddr{1, 2, 3, 4},
+							FCtrl:   FCtrl{ADR: true, ACK: true},
+							FCnt:    10,
+							FOpts: []MACCommand{
+								{CID: LinkCheckReq},
+								{CID: LinkADRAns, Payload: &LinkADRAnsPayload{ChannelMaskACK: true, PowerACK: true}},
+								{CID: DevStatusAns, Payload: &DevStatusAnsPayload{Battery: 100, Margin: -5}},
+							},
+						},
+						FPort:      &fPort10,
+						FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3}}},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "downlink with MAC commands in the FRMPayload",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: ConfirmedDataDown, Major: LoRaWANR1},
+					MACPayload: &MACPayload{
+						FHDR: FHDR{
+							DevAddr: DevAddr{1, 2, 3, 4},
+							FCtrl:   FCtrl{FPending: true},
+						},
+						FPort: &fPort0,
+						FRMPayload: []Payload{
+							&MACCommand{CID: DevStatusReq},
+							&MACCommand{CID: LinkADRReq, Payload: &LinkADRReqPayload{DataRate: 5, TXPower: 2, ChMask: ChMask{true, true, true}, Redundancy: Redundancy{NbRep: 1}}},
+							&MACCommand{CID: 0x80, Payload: &UnknownMACCommands{Bytes: []byte{1, 2}}},
+						},
+					},
+					MIC: [4]byte{4, 3, 2, 1},
+				},
+			},
+			{
+				Name: "join-request",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: JoinRequest, Major: LoRaWANR1},
+					MACPayload: &JoinRequestPayload{
+						AppEUI:   EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+						DevEUI:   EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+						DevNonce: [2]byte{1, 2},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "join-accept",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: JoinAccept, Major: LoRaWANR1},
+					MACPayload: &JoinAcceptPayload{
+						AppNonce:   [3]byte{1, 2, 3},
+						NetID:      [3]byte{3, 2, 1},
+						DevAddr:    DevAddr{1, 2, 3, 4},
+						DLSettings: DLsettings{RX2DataRate: 2, RX1DRoffset: 1},
+						RXDelay:    1,
+						CFList:     &CFList{867100000, 867300000},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "rejoin-request type 1",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: RejoinRequest, Major: LoRaWANR1},
+					MACPayload: &RejoinRequestType1Payload{
+						RejoinType: RejoinRequestType1,
+						JoinEUI:    EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+						DevEUI:     EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+						RJCount1:   123,
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+		}
+
+		for _, test := range testTable {
+			Convey("Testing: "+test.Name, func() {
+				b, err := json.Marshal(test.PHYPayload)
+				So(err, ShouldBeNil)
+
+				var phy PHYPayload
+				So(json.Unmarshal(b, &phy), ShouldBeNil)
+				So(phy, ShouldResemble, test.PHYPayload)
+			})
+		}
+	})
+
+	Convey("Given an uplink PHYPayload with a LinkADRAns in the FOpts", t, func() {
+		phy := PHYPayload{
+			MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+			MACPayload: &MACPayload{
+				FHDR: FHDR{
+					DevAddr: DevAddr{1, 2, 3, 4},
+					FOpts: []MACCommand{
+						{CID: LinkADRAns, Payload: &LinkADRAnsPayload{ChannelMaskACK: true}},
+					},
+				},
+			},
+			MIC: [4]byte{1, 2, 3, 4},
+		}
+
+		Convey("Then MarshalJSON contains the type discriminators and MAC command names", func() {
+			b, err := json.Marshal(phy)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `{"mhdr":{"mType":"UnconfirmedDataUp","major":0},"macPayload":{"type":"MACPayload","value":{"fhdr":{"devAddr":"01020304","fCtrl":{"ADR":false,"ADRACKReq":false,"ACK":false,"ClassB":false,"FPending":false,"RFU":false},"fCnt":0,"fOpts":[{"cid":"LinkADRAns","payload":{"ChannelMaskACK":true,"DataRateACK":false,"PowerACK":false,"RFU":0}}]}}},"mic":"01020304"}`)
+		})
+	})
+
+	Convey("Given a base64 encoded PHYPayload as JSON string", t, func() {
+		phy := PHYPayload{
+			MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+			MACPayload: &MACPayload{
+				FHDR: FHDR{DevAddr: DevAddr{1, 2, 3, 4}},
+			},
+			MIC: [4]byte{1, 2, 3, 4},
+		}
+		b, err := phy.MarshalText()
+		So(err, ShouldBeNil)
+
+		Convey("Then UnmarshalJSON decodes the PHYPayload", func() {
+			var out PHYPayload
+			So(json.Unmarshal([]byte(`"`+string(b)+`"`), &out), ShouldBeNil)
+			So(out.MACPayload, ShouldResemble, phy.MACPayload)
+			So(out.MIC, ShouldEqual, phy.MIC)
+		})
+	})
+
+	Convey("Given JSON with an unknown MAC command name", t, func() {
+		b := []byte(`{"mhdr":{"mType":"UnconfirmedDataUp","major":0},"macPayload":{"type":"MACPayload","value":{"fhdr":{"devAddr":"01020304","fCtrl":{},"fCnt":0,"fOpts":[{"cid":"FooReq"}]}}},"mic":"01020304"}`)
+
+		Convey("Then UnmarshalJSON returns an error", func() {
+			var phy PHYPayload
+			So(json.Unmarshal(b, &phy), ShouldResemble, errors.New("lorawan: unknown MAC command FooReq for uplink=true"))
+		})
+	})
+	Convey("Given an uplink with a proprietary MAC command decoded by a codec with this MAC command registered", t, func() {
+		codec := NewCodec(WithMACCommand(true, 0x80, 2, func() MACCommandPayload { return &testProprietaryMACPayload{} }))
+		b := []byte{0x40, 4, 3, 2, 1, 3, 0, 0, 0x80, 0x07, 0x00, 1, 2, 3, 4}
+		var phy PHYPayload
+		So(codec.UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+
+		jsonB, err := json.Marshal(phy)
+		So(err, ShouldBeNil)
+		So(string(jsonB), ShouldContainSubstring, `{"cid":"0x80","payload":{"Value":7}}`)
+
+		Convey("Then UnmarshalPHYPayloadJSON of the codec decodes the MAC command", func() {
+			var out PHYPayload
+			So(codec.UnmarshalPHYPayloadJSON(&out, jsonB), ShouldBeNil)
+			So(out.MACPayload.(*MACPayload).FHDR.FOpts, ShouldResemble, phy.MACPayload.(*MACPayload).FHDR.FOpts)
+
+			outB, err := out.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(outB, ShouldResemble, b)
+		})
+
+		Convey("Then UnmarshalJSON returns an error as the MAC command is not registered with the DefaultCodec", func() {
+			var out PHYPayload
+			So(json.Unmarshal(jsonB, &out), ShouldResemble, errors.New("lorawan: MAC command 0x80 is not registered with the codec"))
+		})
+	})
+
+	Convey("Given an uplink with a BeaconTimingReq decoded by a LoRaWAN 1.0.3 codec", t, func() {
+		codec := NewCodec(WithCodecMACVersion(LoRaWAN1_0_3))
+		b := []byte{0x40, 4, 3, 2, 1, 1, 0, 0, 0x12, 1, 2, 3, 4}
+		var phy PHYPayload
+		So(codec.UnmarshalPHYPayload(&phy, b), ShouldBeNil)
+
+		jsonB, err := json.Marshal(phy)
+		So(err, ShouldBeNil)
+
+		Convey("Then the MAC command is encoded as unknown MAC command", func() {
+			So(string(jsonB), ShouldContainSubstring, `{"cid":"0x12","unknown":true,"payload":{"Bytes":""}}`)
+		})
+
+		Convey("Then UnmarshalJSON and UnmarshalPHYPayloadJSON decode the original frame", func() {
+			for _, c := range []*Codec{DefaultCodec, codec} {
+				var out PHYPayload
+				So(c.UnmarshalPHYPayloadJSON(&out, jsonB), ShouldBeNil)
+				So(out.MACPayload.(*MACPayload).FHDR.FOpts, ShouldResemble, phy.MACPayload.(*MACPayload).FHDR.FOpts)
+
+				outB, err := out.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(outB, ShouldResemble, b)
+			}
+
+			var out PHYPayload
+			So(json.Unmarshal(jsonB, &out), ShouldBeNil)
+			So(out.MACPayload.(*MACPayload).FHDR.FOpts, ShouldResemble, phy.MACPayload.(*MACPayload).FHDR.FOpts)
+		})
+	})
+
+	Convey("Given JSON with a MAC command payload containing an unknown field", t, func() {
+		b := []byte(`{"mhdr":{"mType":"UnconfirmedDataUp","major":0},"macPayload":{"type":"MACPayload","value":{"fhdr":{"devAddr":"01020304","fCtrl":{},"fCnt":0,"fOpts":[{"cid":"LinkADRAns","payload":{"Foo":true}}]}}},"mic":"01020304"}`)
+
+		Convey("Then UnmarshalJSON returns an error", func() {
+			var phy PHYPayload
+			So(json.Unmarshal(b, &phy), ShouldResemble, errors.New(`json: unknown field "Foo"`))
+		})
+	})
+}
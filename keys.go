@@ -0,0 +1,314 @@
+package lorawan
+
+import (
+	"errors"
+)
+
+// DeriveSessionKeys derives the NwkSKey and AppSKey from the AppKey, the
+// join-request and the (decrypted) join-accept payload.
+// See section '6.2.5 Join-accept message' of the LoRaWAN 1.0 specification
+// for more details.
+func DeriveSessionKeys(appKey AES128Key, jrPL JoinRequestPayload, jaPL JoinAcceptPayload) (nwkSKey AES128Key, appSKey AES128Key, err error) {
+	nwkSKey, err = DeriveNwkSKey(appKey, jaPL.AppNonce, jaPL.NetID, jrPL.DevNonce)
+	if err != nil {
+		return
+	}
+	appSKey, err = DeriveAppSKey(appKey, jaPL.AppNonce, jaPL.NetID, jrPL.DevNonce)
+	return
+}
+
+// DeriveNwkSKey derives the NwkSKey:
+// aes128_encrypt(AppKey, 0x01 | AppNonce | NetID | DevNonce | pad16)
+func DeriveNwkSKey(appKey AES128Key, appNonce [3]byte, netID [3]byte, devNonce [2]byte) (AES128Key, error) {
+	return deriveSessionKey(0x01, appKey, appNonce, netID, devNonce)
+}
+
+// DeriveAppSKey derives the AppSKey:
+// aes128_encrypt(AppKey, 0x02 | AppNonce | NetID | DevNonce | pad16)
+func DeriveAppSKey(appKey AES128Key, appNonce [3]byte, netID [3]byte, devNonce [2]byte) (AES128Key, error) {
+	return deriveSessionKey(0x02, appKey, appNonce, netID, devNonce)
+}
+
+// DeriveSessionKeysWithProvider derives the NwkSKey and AppSKey using the
+// given KeyProvider and AppKey handle. It returns the handles of the derived
+// keys.
+func DeriveSessionKeysWithProvider(provider KeyProvider, appKey KeyHandle, jrPL JoinRequestPayload, jaPL JoinAcceptPayload) (nwkSKey KeyHandle, appSKey KeyHandle, err error) {
+	nwkSKey, err = provider.DeriveKey(appKey, sessionKeyBlock(0x01, jaPL.AppNonce, jaPL.NetID, jrPL.DevNonce))
+	if err != nil {
+		return
+	}
+	appSKey, err = provider.DeriveKey(appKey, sessionKeyBlock(0x02, jaPL.AppNonce, jaPL.NetID, jrPL.DevNonce))
+	return
+}
+
+func deriveSessionKey(typ byte, appKey AES128Key, appNonce [3]byte, netID [3]byte, devNonce [2]byte) (AES128Key, error) {
+	return deriveKey(appKey, sessionKeyBlock(typ, appNonce, netID, devNonce))
+}
+
+func sessionKeyBlock(typ byte, appNonce [3]byte, netID [3]byte, devNonce [2]byte) [16]byte {
+	var b [16]byte
+	b[0] = typ
+
+	// little endian
+	for i, v := range appNonce {
+		b[3-i] = v
+	}
+	for i, v := range netID {
+		b[6-i] = v
+	}
+	b[7] = devNonce[1]
+	b[8] = devNonce[0]
+
+	return b
+}
+
+// deriveKey returns aes128_encrypt(key, b).
+func deriveKey(key AES128Key, b [16]byte) (AES128Key, error) {
+	h, err := SoftwareKeyProvider{}.DeriveKey(key, b)
+	if err != nil {
+		return AES128Key{}, err
+	}
+	out, ok := h.(AES128Key)
+	if !ok {
+		return AES128Key{}, errors.New("lorawan: AES128Key expected")
+	}
+	return out, nil
+}
+
+// RootKeys contains the root keys of a LoRaWAN 1.1 device. The NwkKey is
+// used to derive the network session keys and the join server keys, the
+// AppKey is used to derive the AppSKey.
+type RootKeys struct {
+	NwkKey AES128Key
+	AppKey AES128Key
+}
+
+// SessionKeys contains the session keys of an activated device. For
+// LoRaWAN 1.0 devices, FNwkSIntKey, SNwkSIntKey and NwkSEncKey are all set
+// to the NwkSKey (see LoRaWAN10SessionKeys). The session keys can be used
+// directly by PHYPayload.SetMICWithSessionKeys, ValidateMICWithSessionKeys,
+// EncryptFRMPayloadWithSessionKeys and DecryptFRMPayloadWithSessionKeys.
+type SessionKeys struct {
+	FNwkSIntKey AES128Key // used for the uplink MIC (cmacF)
+	SNwkSIntKey AES128Key // used for the uplink (cmacS) and downlink MIC
+	NwkSEncKey  AES128Key // used for encrypting MAC commands
+	AppSKey     AES128Key // used for encrypting application data
+}
+
+// LoRaWAN10SessionKeys returns the SessionKeys for a LoRaWAN 1.0 device.
+func LoRaWAN10SessionKeys(nwkSKey, appSKey AES128Key) SessionKeys {
+	return SessionKeys{
+		FNwkSIntKey: nwkSKey,
+		SNwkSIntKey: nwkSKey,
+		NwkSEncKey:  nwkSKey,
+		AppSKey:     appSKey,
+	}
+}
+
+// FRMPayloadKey returns the key for encrypting and decrypting the FRMPayload
+// with the given FPort: the NwkSEncKey when the FRMPayload contains MAC
+// commands (FPort=0), the AppSKey otherwise.
+func (k SessionKeys) FRMPayloadKey(fPort uint8) AES128Key {
+	if fPort == 0 {
+		return k.NwkSEncKey
+	}
+	return k.AppSKey
+}
+
+// micKey returns the key and MIC options for calculating the data-frame
+// MIC of the given PHYPayload: the FNwkSIntKey and SNwkSIntKey for uplink
+// frames, the SNwkSIntKey for downlink frames.
+func (k SessionKeys) micKey(p *PHYPayload, opts []MICOption) (AES128Key, []MICOption) {
+	if !p.isUplink() {
+		return k.SNwkSIntKey, opts
+	}
+	return k.FNwkSIntKey, append([]MICOption{WithSNwkSIntKey(k.SNwkSIntKey)}, opts...)
+}
+
+// SetMICWithSessionKeys calculates and sets the MIC of the data frame using
+// the matching session key(s). The same options as for SetMIC can be used.
+func (p *PHYPayload) SetMICWithSessionKeys(keys SessionKeys, opts ...MICOption) error {
+	if _, ok := p.MACPayload.(*MACPayload); !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+	key, opts := keys.micKey(p, opts)
+	return p.SetMIC(key, opts...)
+}
+
+// ValidateMICWithSessionKeys returns if the MIC of the data frame is valid,
+// using the matching session key(s). The same options as for ValidateMIC
+// can be used.
+func (p PHYPayload) ValidateMICWithSessionKeys(keys SessionKeys, opts ...MICOption) (bool, error) {
+	if _, ok := p.MACPayload.(*MACPayload); !ok {
+		return false, errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+	key, opts := keys.micKey(&p, opts)
+	return p.ValidateMIC(key, opts...)
+}
+
+// EncryptFRMPayloadWithSessionKeys encrypts the FRMPayload using the
+// session key for the FPort of the frame (see FRMPayloadKey).
+func (p *PHYPayload) EncryptFRMPayloadWithSessionKeys(keys SessionKeys) error {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+
+	// nothing to encrypt
+	if macPL.FPort == nil {
+		return nil
+	}
+	return p.EncryptFRMPayload(keys.FRMPayloadKey(*macPL.FPort))
+}
+
+// DecryptFRMPayloadWithSessionKeys decrypts the FRMPayload using the
+// session key for the FPort of the frame (see FRMPayloadKey).
+func (p *PHYPayload) DecryptFRMPayloadWithSessionKeys(keys SessionKeys) error {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+
+	// nothing to decrypt
+	if macPL.FPort == nil {
+		return nil
+	}
+	return p.DecryptFRMPayload(keys.FRMPayloadKey(*macPL.FPort))
+}
+
+// DeriveLoRaWAN11SessionKeys derives the session keys of a LoRaWAN 1.1
+// device. In case of a join-request, joinEUI and devNonce are the JoinEUI
+// (AppEUI) and DevNonce of the join-request, in case of a rejoin-request
+// the DevNonce must be set to the RJcount0 or RJcount1 value.
+// See section '6.2.3 Join-accept message' of the LoRaWAN 1.1 specification
+// for more details.
+func DeriveLoRaWAN11SessionKeys(rootKeys RootKeys, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (SessionKeys, error) {
+	var keys SessionKeys
+	var err error
+
+	if keys.FNwkSIntKey, err = DeriveFNwkSIntKey(rootKeys.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return keys, err
+	}
+	if keys.SNwkSIntKey, err = DeriveSNwkSIntKey(rootKeys.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return keys, err
+	}
+	if keys.NwkSEncKey, err = DeriveNwkSEncKey(rootKeys.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return keys, err
+	}
+	if keys.AppSKey, err = deriveLoRaWAN11SessionKey(0x02, rootKeys.AppKey, joinNonce, joinEUI, devNonce); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// DeriveFNwkSIntKey derives the FNwkSIntKey:
+// aes128_encrypt(NwkKey, 0x01 | JoinNonce | JoinEUI | DevNonce | pad16)
+func DeriveFNwkSIntKey(nwkKey AES128Key, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (AES128Key, error) {
+	return deriveLoRaWAN11SessionKey(0x01, nwkKey, joinNonce, joinEUI, devNonce)
+}
+
+// DeriveSNwkSIntKey derives the SNwkSIntKey:
+// aes128_encrypt(NwkKey, 0x03 | JoinNonce | JoinEUI | DevNonce | pad16)
+func DeriveSNwkSIntKey(nwkKey AES128Key, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (AES128Key, error) {
+	return deriveLoRaWAN11SessionKey(0x03, nwkKey, joinNonce, joinEUI, devNonce)
+}
+
+// DeriveNwkSEncKey derives the NwkSEncKey:
+// aes128_encrypt(NwkKey, 0x04 | JoinNonce | JoinEUI | DevNonce | pad16)
+func DeriveNwkSEncKey(nwkKey AES128Key, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (AES128Key, error) {
+	return deriveLoRaWAN11SessionKey(0x04, nwkKey, joinNonce, joinEUI, devNonce)
+}
+
+// DeriveJSIntKey derives the JSIntKey, used for the MIC of the rejoin-request
+// (type 1) and of the LoRaWAN 1.1 join-accept:
+// aes128_encrypt(NwkKey, 0x06 | DevEUI | pad16)
+func DeriveJSIntKey(nwkKey AES128Key, devEUI EUI64) (AES128Key, error) {
+	return deriveJSKey(0x06, nwkKey, devEUI)
+}
+
+// DeriveJSEncKey derives the JSEncKey, used for encrypting the join-accept
+// answering a rejoin-request:
+// aes128_encrypt(NwkKey, 0x05 | DevEUI | pad16)
+func DeriveJSEncKey(nwkKey AES128Key, devEUI EUI64) (AES128Key, error) {
+	return deriveJSKey(0x05, nwkKey, devEUI)
+}
+
+// SessionKeyHandles contains the handles of the session keys, as derived
+// by DeriveLoRaWAN11SessionKeysWithProvider.
+type SessionKeyHandles struct {
+	FNwkSIntKey KeyHandle
+	SNwkSIntKey KeyHandle
+	NwkSEncKey  KeyHandle
+	AppSKey     KeyHandle
+}
+
+// DeriveLoRaWAN11SessionKeysWithProvider derives the session keys of a
+// LoRaWAN 1.1 device using the given KeyProvider and NwkKey and AppKey
+// handles.
+func DeriveLoRaWAN11SessionKeysWithProvider(provider KeyProvider, nwkKey, appKey KeyHandle, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (SessionKeyHandles, error) {
+	var keys SessionKeyHandles
+	var err error
+
+	if keys.FNwkSIntKey, err = provider.DeriveKey(nwkKey, lorawan11SessionKeyBlock(0x01, joinNonce, joinEUI, devNonce)); err != nil {
+		return keys, err
+	}
+	if keys.SNwkSIntKey, err = provider.DeriveKey(nwkKey, lorawan11SessionKeyBlock(0x03, joinNonce, joinEUI, devNonce)); err != nil {
+		return keys, err
+	}
+	if keys.NwkSEncKey, err = provider.DeriveKey(nwkKey, lorawan11SessionKeyBlock(0x04, joinNonce, joinEUI, devNonce)); err != nil {
+		return keys, err
+	}
+	if keys.AppSKey, err = provider.DeriveKey(appKey, lorawan11SessionKeyBlock(0x02, joinNonce, joinEUI, devNonce)); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// DeriveJSIntKeyWithProvider derives the JSIntKey using the given
+// KeyProvider and NwkKey handle.
+func DeriveJSIntKeyWithProvider(provider KeyProvider, nwkKey KeyHandle, devEUI EUI64) (KeyHandle, error) {
+	return provider.DeriveKey(nwkKey, jsKeyBlock(0x06, devEUI))
+}
+
+// DeriveJSEncKeyWithProvider derives the JSEncKey using the given
+// KeyProvider and NwkKey handle.
+func DeriveJSEncKeyWithProvider(provider KeyProvider, nwkKey KeyHandle, devEUI EUI64) (KeyHandle, error) {
+	return provider.DeriveKey(nwkKey, jsKeyBlock(0x05, devEUI))
+}
+
+func deriveLoRaWAN11SessionKey(typ byte, key AES128Key, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) (AES128Key, error) {
+	return deriveKey(key, lorawan11SessionKeyBlock(typ, joinNonce, joinEUI, devNonce))
+}
+
+func lorawan11SessionKeyBlock(typ byte, joinNonce [3]byte, joinEUI EUI64, devNonce [2]byte) [16]byte {
+	var b [16]byte
+	b[0] = typ
+
+	// little endian
+	for i, v := range joinNonce {
+		b[3-i] = v
+	}
+	for i, v := range joinEUI {
+		b[11-i] = v
+	}
+	b[12] = devNonce[1]
+	b[13] = devNonce[0]
+
+	return b
+}
+
+func deriveJSKey(typ byte, nwkKey AES128Key, devEUI EUI64) (AES128Key, error) {
+	return deriveKey(nwkKey, jsKeyBlock(typ, devEUI))
+}
+
+func jsKeyBlock(typ byte, devEUI EUI64) [16]byte {
+	var b [16]byte
+	b[0] = typ
+
+	// little endian
+	for i, v := range devEUI {
+		b[8-i] = v
+	}
+
+	return b
+}
@@ -0,0 +1,167 @@
+package lorawan
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeriveSessionKeys(t *testing.T) {
+	Convey("Given AppKey=00112233445566778899aabbccddeeff, AppNonce=[3]byte{87, 11, 199}, NetID=[3]byte{34, 17, 1} and DevNonce=[2]byte{16, 45}", t, func() {
+		var appKey AES128Key
+		So(appKey.UnmarshalText([]byte("00112233445566778899aabbccddeeff")), ShouldBeNil)
+		appNonce := [3]byte{87, 11, 199}
+		netID := [3]byte{34, 17, 1}
+		devNonce := [2]byte{16, 45}
+
+		Convey("Then DeriveNwkSKey returns 7bb25f89e0d1371e1fbf4d997e1468a3", func() {
+			key, err := DeriveNwkSKey(appKey, appNonce, netID, devNonce)
+			So(err, ShouldBeNil)
+			So(key.String(), ShouldEqual, "7bb25f89e0d1371e1fbf4d997e1468a3")
+		})
+
+		Convey("Then DeriveAppSKey returns 148820dfb1e0c9d6289cde16c1af249f", func() {
+			key, err := DeriveAppSKey(appKey, appNonce, netID, devNonce)
+			So(err, ShouldBeNil)
+			So(key.String(), ShouldEqual, "148820dfb1e0c9d6289cde16c1af249f")
+		})
+
+		Convey("Given the encrypted join-accept 20493eeb51fba2116f810edb3742975142 and a join-request with DevNonce=[2]byte{16, 45}", func() {
+			jaBytes, err := hex.DecodeString("20493eeb51fba2116f810edb3742975142")
+			So(err, ShouldBeNil)
+
+			var phy PHYPayload
+			So(phy.UnmarshalBinary(jaBytes), ShouldBeNil)
+			So(phy.DecryptJoinAcceptPayload(appKey), ShouldBeNil)
+			jaPL, ok := phy.MACPayload.(*JoinAcceptPayload)
+			So(ok, ShouldBeTrue)
+
+			jrPL := JoinRequestPayload{DevNonce: devNonce}
+
+			Convey("Then DeriveSessionKeys returns the expected NwkSKey and AppSKey", func() {
+				nwkSKey, appSKey, err := DeriveSessionKeys(appKey, jrPL, *jaPL)
+				So(err, ShouldBeNil)
+				So(nwkSKey.String(), ShouldEqual, "7bb25f89e0d1371e1fbf4d997e1468a3")
+				So(appSKey.String(), ShouldEqual, "148820dfb1e0c9d6289cde16c1af249f")
+			})
+		})
+	})
+}
+
+func TestDeriveLoRaWAN11SessionKeys(t *testing.T) {
+	Convey("Given NwkKey=00112233445566778899aabbccddeeff, AppKey=ffeeddccbbaa99887766554433221100, JoinNonce=[3]byte{1, 2, 3}, JoinEUI=0102030405060708 and DevNonce=[2]byte{1, 2}", t, func() {
+		var rootKeys RootKeys
+		So(rootKeys.NwkKey.UnmarshalText([]byte("00112233445566778899aabbccddeeff")), ShouldBeNil)
+		So(rootKeys.AppKey.UnmarshalText([]byte("ffeeddccbbaa99887766554433221100")), ShouldBeNil)
+		joinNonce := [3]byte{1, 2, 3}
+		joinEUI := EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+		devNonce := [2]byte{1, 2}
+
+		Convey("Then DeriveLoRaWAN11SessionKeys returns the expected keys", func() {
+			keys, err := DeriveLoRaWAN11SessionKeys(rootKeys, joinNonce, joinEUI, devNonce)
+			So(err, ShouldBeNil)
+			So(keys.FNwkSIntKey.String(), ShouldEqual, "6cc5224e67aac74fee5d71a56ccc9368")
+			So(keys.SNwkSIntKey.String(), ShouldEqual, "ee9badff96164ec5258108f6c1dd14bf")
+			So(keys.NwkSEncKey.String(), ShouldEqual, "c0c7471b05add21ecd8bf1df694ae5c1")
+			So(keys.AppSKey.String(), ShouldEqual, "4eea617d419e7bdf17d285021e07baa6")
+
+			Convey("Then FRMPayloadKey returns the NwkSEncKey for FPort=0 and the AppSKey otherwise", func() {
+				So(keys.FRMPayloadKey(0), ShouldEqual, keys.NwkSEncKey)
+				So(keys.FRMPayloadKey(1), ShouldEqual, keys.AppSKey)
+			})
+		})
+
+		Convey("Given DevEUI=0102030405060708", func() {
+			devEUI := EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+			Convey("Then DeriveJSIntKey returns a14cc8c0ad4fd276644a5ab5df16dfd7", func() {
+				key, err := DeriveJSIntKey(rootKeys.NwkKey, devEUI)
+				So(err, ShouldBeNil)
+				So(key.String(), ShouldEqual, "a14cc8c0ad4fd276644a5ab5df16dfd7")
+			})
+
+			Convey("Then DeriveJSEncKey returns 06fdf3598cd70bb4ef403cefc4b6effd", func() {
+				key, err := DeriveJSEncKey(rootKeys.NwkKey, devEUI)
+				So(err, ShouldBeNil)
+				So(key.String(), ShouldEqual, "06fdf3598cd70bb4ef403cefc4b6effd")
+			})
+		})
+	})
+
+	Convey("Given a set of LoRaWAN 1.1 session keys", t, func() {
+		keys := SessionKeys{
+			FNwkSIntKey: AES128Key{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+			SNwkSIntKey: AES128Key{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+			NwkSEncKey:  AES128Key{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+			AppSKey:     AES128Key{4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
+		}
+
+		Convey("Given an uplink with MAC commands in the FRMPayload", func() {
+			fPort := uint8(0)
+			newUplink := func() PHYPayload {
+				return PHYPayload{
+					MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+					MACPayload: &MACPayload{
+						FHDR:       FHDR{DevAddr: DevAddr{1, 2, 3, 4}, FCnt: 10},
+						FPort:      &fPort,
+						FRMPayload: []Payload{&MACCommand{CID: LinkCheckReq}},
+					},
+				}
+			}
+
+			Convey("Then SetMICWithSessionKeys uses the FNwkSIntKey and SNwkSIntKey", func() {
+				phyA := newUplink()
+				phyB := newUplink()
+				So(phyA.SetMICWithSessionKeys(keys, WithMACVersion(LoRaWAN1_1), WithTxDR(3)), ShouldBeNil)
+				So(phyB.SetMIC(keys.FNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithSNwkSIntKey(keys.SNwkSIntKey), WithTxDR(3)), ShouldBeNil)
+				So(phyA.MIC, ShouldEqual, phyB.MIC)
+
+				ok, err := phyA.ValidateMICWithSessionKeys(keys, WithMACVersion(LoRaWAN1_1), WithTxDR(3))
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Then EncryptFRMPayloadWithSessionKeys uses the NwkSEncKey", func() {
+				phyA := newUplink()
+				phyB := newUplink()
+				So(phyA.EncryptFRMPayloadWithSessionKeys(keys), ShouldBeNil)
+				So(phyB.EncryptFRMPayload(keys.NwkSEncKey), ShouldBeNil)
+				So(phyA, ShouldResemble, phyB)
+
+				So(phyA.DecryptFRMPayloadWithSessionKeys(keys), ShouldBeNil)
+				So(phyA.MACPayload.(*MACPayload).FRMPayload, ShouldResemble, newUplink().MACPayload.(*MACPayload).FRMPayload)
+			})
+		})
+
+		Convey("Given a downlink", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{MType: UnconfirmedDataDown, Major: LoRaWANR1},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{DevAddr: DevAddr{1, 2, 3, 4}, FCnt: 10},
+				},
+			}
+
+			Convey("Then SetMICWithSessionKeys uses the SNwkSIntKey", func() {
+				So(phy.SetMICWithSessionKeys(keys, WithMACVersion(LoRaWAN1_1)), ShouldBeNil)
+				ok, err := phy.ValidateMIC(keys.SNwkSIntKey, WithMACVersion(LoRaWAN1_1))
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a LoRaWAN 1.0 NwkSKey and AppSKey", t, func() {
+		nwkSKey := AES128Key{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+		appSKey := AES128Key{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+
+		Convey("Then LoRaWAN10SessionKeys uses the NwkSKey for all network session keys", func() {
+			So(LoRaWAN10SessionKeys(nwkSKey, appSKey), ShouldResemble, SessionKeys{
+				FNwkSIntKey: nwkSKey,
+				SNwkSIntKey: nwkSKey,
+				NwkSEncKey:  nwkSKey,
+				AppSKey:     appSKey,
+			})
+		})
+	})
+}
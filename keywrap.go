@@ -0,0 +1,167 @@
+package lorawan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// keyWrapIV is the default initial value as defined by RFC 3394.
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// HEXBytes defines a type which represents bytes as HEX when marshaled to
+// text.
+type HEXBytes []byte
+
+// String implements fmt.Stringer.
+func (hb HEXBytes) String() string {
+	return hex.EncodeToString(hb[:])
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (hb HEXBytes) MarshalText() ([]byte, error) {
+	return []byte(hb.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (hb *HEXBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*hb = HEXBytes(b)
+	return nil
+}
+
+// KeyEnvelope defines a key envelope, used for transporting (session) keys
+// between the join server, network server and application server.
+// When KEKLabel is set, AESKey contains the key wrapped with the KEK
+// identified by this label (RFC 3394), else AESKey contains the plain key.
+type KeyEnvelope struct {
+	KEKLabel string   `json:"kekLabel"`
+	AESKey   HEXBytes `json:"aesKey"`
+}
+
+// NewKeyEnvelope returns a KeyEnvelope containing the given key, wrapped
+// with the KEK. When kekLabel is empty, the key is not wrapped.
+func NewKeyEnvelope(kekLabel string, kek AES128Key, key AES128Key) (KeyEnvelope, error) {
+	if kekLabel == "" {
+		return KeyEnvelope{AESKey: HEXBytes(key[:])}, nil
+	}
+
+	b, err := WrapKey(kek, key)
+	if err != nil {
+		return KeyEnvelope{}, err
+	}
+
+	return KeyEnvelope{
+		KEKLabel: kekLabel,
+		AESKey:   HEXBytes(b),
+	}, nil
+}
+
+// Unwrap returns the key from the envelope, unwrapped with the given KEK.
+// The KEK is ignored when KEKLabel is empty.
+func (e KeyEnvelope) Unwrap(kek AES128Key) (AES128Key, error) {
+	var key AES128Key
+
+	if e.KEKLabel == "" {
+		if len(e.AESKey) != len(key) {
+			return key, errors.New("lorawan: exactly 16 bytes are expected")
+		}
+		copy(key[:], e.AESKey)
+		return key, nil
+	}
+
+	return UnwrapKey(kek, e.AESKey)
+}
+
+// WrapKey wraps the key with the given KEK, using the AES key wrap
+// algorithm as defined by RFC 3394.
+func WrapKey(kek AES128Key, key AES128Key) ([]byte, error) {
+	return wrapKey(kek, key[:])
+}
+
+// UnwrapKey unwraps the wrapped key with the given KEK, using the AES key
+// unwrap algorithm as defined by RFC 3394. An error is returned when the
+// integrity check fails.
+func UnwrapKey(kek AES128Key, wrapped []byte) (AES128Key, error) {
+	var key AES128Key
+
+	b, err := unwrapKey(kek, wrapped)
+	if err != nil {
+		return key, err
+	}
+	if len(b) != len(key) {
+		return key, errors.New("lorawan: exactly 16 bytes are expected")
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// wrapKey implements the key wrap algorithm of RFC 3394 section 2.2.1.
+func wrapKey(kek AES128Key, plaintext []byte) ([]byte, error) {
+	if len(plaintext) < 16 || len(plaintext)%8 != 0 {
+		return nil, errors.New("lorawan: key data must be a multiple of 8 bytes and at least 16 bytes")
+	}
+
+	var provider SoftwareKeyProvider
+	n := len(plaintext) / 8
+
+	// out contains A | R[1] | ... | R[n]
+	out := make([]byte, 8+len(plaintext))
+	copy(out[0:8], keyWrapIV)
+	copy(out[8:], plaintext)
+
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[0:8], out[0:8])
+			copy(b[8:16], out[i*8:i*8+8])
+			if err := provider.encryptBlocks(kek, b, b); err != nil {
+				return nil, err
+			}
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[0:8], binary.BigEndian.Uint64(b[0:8])^t)
+			copy(out[i*8:i*8+8], b[8:16])
+		}
+	}
+
+	return out, nil
+}
+
+// unwrapKey implements the key unwrap algorithm of RFC 3394 section 2.2.2.
+func unwrapKey(kek AES128Key, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 24 || len(ciphertext)%8 != 0 {
+		return nil, errors.New("lorawan: wrapped key must be a multiple of 8 bytes and at least 24 bytes")
+	}
+
+	var provider SoftwareKeyProvider
+	n := len(ciphertext)/8 - 1
+
+	out := make([]byte, len(ciphertext))
+	copy(out, ciphertext)
+
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[0:8], binary.BigEndian.Uint64(out[0:8])^t)
+			copy(b[8:16], out[i*8:i*8+8])
+			if err := provider.decryptBlocks(kek, b, b); err != nil {
+				return nil, err
+			}
+
+			copy(out[0:8], b[0:8])
+			copy(out[i*8:i*8+8], b[8:16])
+		}
+	}
+
+	if !bytes.Equal(out[0:8], keyWrapIV) {
+		return nil, errors.New("lorawan: key unwrap integrity check failed")
+	}
+
+	return out[8:], nil
+}
@@ -0,0 +1,88 @@
+package lorawan
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWrapKey(t *testing.T) {
+	Convey("Given KEK=000102030405060708090a0b0c0d0e0f and Key=00112233445566778899aabbccddeeff (RFC 3394 section 4.1)", t, func() {
+		var kek, key AES128Key
+		So(kek.UnmarshalText([]byte("000102030405060708090a0b0c0d0e0f")), ShouldBeNil)
+		So(key.UnmarshalText([]byte("00112233445566778899aabbccddeeff")), ShouldBeNil)
+
+		Convey("Then WrapKey returns 1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5", func() {
+			b, err := WrapKey(kek, key)
+			So(err, ShouldBeNil)
+			So(HEXBytes(b).String(), ShouldEqual, "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5")
+
+			Convey("Then UnwrapKey returns the key", func() {
+				k, err := UnwrapKey(kek, b)
+				So(err, ShouldBeNil)
+				So(k, ShouldEqual, key)
+			})
+
+			Convey("Then UnwrapKey with a modified wrapped key returns an error", func() {
+				b[0] ^= 0x01
+				_, err := UnwrapKey(kek, b)
+				So(err, ShouldResemble, errors.New("lorawan: key unwrap integrity check failed"))
+			})
+
+			Convey("Then UnwrapKey with an other KEK returns an error", func() {
+				_, err := UnwrapKey(AES128Key{}, b)
+				So(err, ShouldResemble, errors.New("lorawan: key unwrap integrity check failed"))
+			})
+		})
+
+		Convey("Then UnwrapKey with an invalid length returns an error", func() {
+			_, err := UnwrapKey(kek, make([]byte, 20))
+			So(err, ShouldResemble, errors.New("lorawan: wrapped key must be a multiple of 8 bytes and at least 24 bytes"))
+		})
+	})
+}
+
+func TestKeyEnvelope(t *testing.T) {
+	Convey("Given KEK=000102030405060708090a0b0c0d0e0f and Key=00112233445566778899aabbccddeeff", t, func() {
+		var kek, key AES128Key
+		So(kek.UnmarshalText([]byte("000102030405060708090a0b0c0d0e0f")), ShouldBeNil)
+		So(key.UnmarshalText([]byte("00112233445566778899aabbccddeeff")), ShouldBeNil)
+
+		Convey("Then NewKeyEnvelope with KEKLabel=kek1 returns the wrapped key", func() {
+			ke, err := NewKeyEnvelope("kek1", kek, key)
+			So(err, ShouldBeNil)
+			So(ke, ShouldResemble, KeyEnvelope{
+				KEKLabel: "kek1",
+				AESKey:   HEXBytes{0x1f, 0xa6, 0x8b, 0x0a, 0x81, 0x12, 0xb4, 0x47, 0xae, 0xf3, 0x4b, 0xd8, 0xfb, 0x5a, 0x7b, 0x82, 0x9d, 0x3e, 0x86, 0x23, 0x71, 0xd2, 0xcf, 0xe5},
+			})
+
+			Convey("Then the JSON representation is as expected", func() {
+				b, err := json.Marshal(ke)
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, `{"kekLabel":"kek1","aesKey":"1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"}`)
+
+				Convey("Then it can be unmarshaled and unwrapped", func() {
+					var out KeyEnvelope
+					So(json.Unmarshal(b, &out), ShouldBeNil)
+					So(out, ShouldResemble, ke)
+
+					k, err := out.Unwrap(kek)
+					So(err, ShouldBeNil)
+					So(k, ShouldEqual, key)
+				})
+			})
+		})
+
+		Convey("Then NewKeyEnvelope without KEKLabel returns the plain key", func() {
+			ke, err := NewKeyEnvelope("", kek, key)
+			So(err, ShouldBeNil)
+			So(ke.AESKey.String(), ShouldEqual, "00112233445566778899aabbccddeeff")
+
+			k, err := ke.Unwrap(AES128Key{})
+			So(err, ShouldBeNil)
+			So(k, ShouldEqual, key)
+		})
+	})
+}
@@ -4,29 +4,53 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 )
 
-// cid defines the MAC command identifier.
-type cid byte
+// CID defines the MAC command identifier.
+type CID byte
 
-// MAC commands as specified by the LoRaWAN R1.0 specs. Note that each *Req / *Ans
-// has the same value. Based on the fact if a message is uplink or downlink
-// you should use on or the other.
+// MAC commands as specified by the LoRaWAN R1.0 and R1.1 specs. Note that each
+// *Req / *Ans (and *Ind / *Conf) has the same value. Based on the fact if a
+// message is uplink or downlink you should use on or the other.
 const (
-	LinkCheckReq     cid = 0x02
-	LinkCheckAns     cid = 0x02
-	LinkADRReq       cid = 0x03
-	LinkADRAns       cid = 0x03
-	DutyCycleReq     cid = 0x04
-	DutyCycleAns     cid = 0x04
-	RXParamSetupReq  cid = 0x05
-	RXParamSetupAns  cid = 0x05
-	DevStatusReq     cid = 0x06
-	DevStatusAns     cid = 0x06
-	NewChannelReq    cid = 0x07
-	NewChannelAns    cid = 0x07
-	RXTimingSetupReq cid = 0x08
-	RXTimingSetupAns cid = 0x08
+	ResetInd            CID = 0x01
+	ResetConf           CID = 0x01
+	LinkCheckReq        CID = 0x02
+	LinkCheckAns        CID = 0x02
+	LinkADRReq          CID = 0x03
+	LinkADRAns          CID = 0x03
+	DutyCycleReq        CID = 0x04
+	DutyCycleAns        CID = 0x04
+	RXParamSetupReq     CID = 0x05
+	RXParamSetupAns     CID = 0x05
+	DevStatusReq        CID = 0x06
+	DevStatusAns        CID = 0x06
+	NewChannelReq       CID = 0x07
+	NewChannelAns       CID = 0x07
+	RXTimingSetupReq    CID = 0x08
+	RXTimingSetupAns    CID = 0x08
+	TXParamSetupReq     CID = 0x09
+	TXParamSetupAns     CID = 0x09
+	DLChannelReq        CID = 0x0A
+	DLChannelAns        CID = 0x0A
+	RekeyInd            CID = 0x0B
+	RekeyConf           CID = 0x0B
+	ADRParamSetupReq    CID = 0x0C
+	ADRParamSetupAns    CID = 0x0C
+	DeviceTimeReq       CID = 0x0D
+	DeviceTimeAns       CID = 0x0D
+	ForceRejoinReq      CID = 0x0E
+	RejoinParamSetupReq CID = 0x0F
+	RejoinParamSetupAns CID = 0x0F
+	PingSlotInfoReq     CID = 0x10
+	PingSlotInfoAns     CID = 0x10
+	PingSlotChannelReq  CID = 0x11
+	PingSlotChannelAns  CID = 0x11
+	BeaconTimingReq     CID = 0x12
+	BeaconTimingAns     CID = 0x12
+	BeaconFreqReq       CID = 0x13
+	BeaconFreqAns       CID = 0x13
 	// 0x80 to 0xFF reserved for proprietary network command extensions
 )
 
@@ -37,32 +61,73 @@ type macPayloadInfo struct {
 }
 
 // macPayloadRegistry contains the info for uplink and downlink MAC payloads
-// in the format map[uplink]map[CID].
-var macPayloadRegistry = map[bool]map[cid]macPayloadInfo{
-	false: map[cid]macPayloadInfo{
-		LinkCheckAns:     {2, func() MACCommandPayload { return &LinkCheckAnsPayload{} }},
-		LinkADRReq:       {4, func() MACCommandPayload { return &LinkADRReqPayload{} }},
-		DutyCycleReq:     {1, func() MACCommandPayload { return &DutyCycleReqPayload{} }},
-		RXParamSetupReq:  {4, func() MACCommandPayload { return &RX2SetupReqPayload{} }},
-		NewChannelReq:    {4, func() MACCommandPayload { return &NewChannelReqPayload{} }},
-		RXTimingSetupReq: {1, func() MACCommandPayload { return &RXTimingSetupReqPayload{} }},
+// in the format map[uplink]map[CID]. MAC commands without payload have size
+// 0 and a nil payload function. It is copied into the MAC command registry
+// of each Codec.
+var macPayloadRegistry = map[bool]map[CID]macPayloadInfo{
+	false: map[CID]macPayloadInfo{
+		DevStatusReq:        {0, nil},
+		PingSlotInfoAns:     {0, nil},
+		LinkCheckAns:        {2, func() MACCommandPayload { return &LinkCheckAnsPayload{} }},
+		LinkADRReq:          {4, func() MACCommandPayload { return &LinkADRReqPayload{} }},
+		DutyCycleReq:        {1, func() MACCommandPayload { return &DutyCycleReqPayload{} }},
+		RXParamSetupReq:     {4, func() MACCommandPayload { return &RX2SetupReqPayload{} }},
+		NewChannelReq:       {5, func() MACCommandPayload { return &NewChannelReqPayload{} }},
+		RXTimingSetupReq:    {1, func() MACCommandPayload { return &RXTimingSetupReqPayload{} }},
+		TXParamSetupReq:     {1, func() MACCommandPayload { return &TXParamSetupReqPayload{} }},
+		DLChannelReq:        {4, func() MACCommandPayload { return &DLChannelReqPayload{} }},
+		DeviceTimeAns:       {5, func() MACCommandPayload { return &DeviceTimeAnsPayload{} }},
+		ResetConf:           {1, func() MACCommandPayload { return &ResetConfPayload{} }},
+		RekeyConf:           {1, func() MACCommandPayload { return &RekeyConfPayload{} }},
+		ADRParamSetupReq:    {1, func() MACCommandPayload { return &ADRParamSetupReqPayload{} }},
+		ForceRejoinReq:      {2, func() MACCommandPayload { return &ForceRejoinReqPayload{} }},
+		RejoinParamSetupReq: {1, func() MACCommandPayload { return &RejoinParamSetupReqPayload{} }},
+		PingSlotChannelReq:  {4, func() MACCommandPayload { return &PingSlotChannelReqPayload{} }},
+		BeaconTimingAns:     {3, func() MACCommandPayload { return &BeaconTimingAnsPayload{} }},
+		BeaconFreqReq:       {3, func() MACCommandPayload { return &BeaconFreqReqPayload{} }},
 	},
-	true: map[cid]macPayloadInfo{
-		LinkADRAns:      {1, func() MACCommandPayload { return &LinkADRAnsPayload{} }},
-		RXParamSetupAns: {1, func() MACCommandPayload { return &RX2SetupAnsPayload{} }},
-		DevStatusAns:    {2, func() MACCommandPayload { return &DevStatusAnsPayload{} }},
-		NewChannelAns:   {1, func() MACCommandPayload { return &NewChannelAnsPayload{} }},
+	true: map[CID]macPayloadInfo{
+		LinkCheckReq:        {0, nil},
+		DutyCycleAns:        {0, nil},
+		RXTimingSetupAns:    {0, nil},
+		TXParamSetupAns:     {0, nil},
+		ADRParamSetupAns:    {0, nil},
+		DeviceTimeReq:       {0, nil},
+		BeaconTimingReq:     {0, nil},
+		LinkADRAns:          {1, func() MACCommandPayload { return &LinkADRAnsPayload{} }},
+		RXParamSetupAns:     {1, func() MACCommandPayload { return &RX2SetupAnsPayload{} }},
+		DevStatusAns:        {2, func() MACCommandPayload { return &DevStatusAnsPayload{} }},
+		NewChannelAns:       {1, func() MACCommandPayload { return &NewChannelAnsPayload{} }},
+		DLChannelAns:        {1, func() MACCommandPayload { return &DLChannelAnsPayload{} }},
+		ResetInd:            {1, func() MACCommandPayload { return &ResetIndPayload{} }},
+		RekeyInd:            {1, func() MACCommandPayload { return &RekeyIndPayload{} }},
+		RejoinParamSetupAns: {1, func() MACCommandPayload { return &RejoinParamSetupAnsPayload{} }},
+		PingSlotInfoReq:     {1, func() MACCommandPayload { return &PingSlotInfoReqPayload{} }},
+		PingSlotChannelAns:  {1, func() MACCommandPayload { return &PingSlotChannelAnsPayload{} }},
+		BeaconFreqAns:       {1, func() MACCommandPayload { return &BeaconFreqAnsPayload{} }},
 	},
 }
 
-// getMACPayloadAndSize returns a new MACCommandPayload instance and it's size.
-func getMACPayloadAndSize(uplink bool, c cid) (MACCommandPayload, int, error) {
-	v, ok := macPayloadRegistry[uplink][c]
-	if !ok {
-		return nil, 0, fmt.Errorf("lorawan: payload unknown for uplink=%v and CID=%v", uplink, c)
-	}
+// getMACPayloadAndSize returns a new MACCommandPayload instance and it's
+// size, using the DefaultCodec. For MAC commands without payload, the
+// returned MACCommandPayload is nil.
+func getMACPayloadAndSize(uplink bool, c CID) (MACCommandPayload, int, error) {
+	return DefaultCodec.getMACPayloadAndSize(uplink, c)
+}
 
-	return v.payload(), v.size, nil
+// RegisterMACCommand registers a proprietary MAC command (CID 0x80 - 0xFF)
+// for the given direction, so that it is decoded as part of the FOpts and
+// FRMPayload (FPort=0). This changes the DefaultCodec only, codecs created
+// by NewCodec are not affected (see Codec.RegisterMACCommand). size is the
+// size of the payload in bytes and payload must return a new instance of
+// the payload type. For MAC commands without payload, size must be 0 and
+// payload nil. An error is returned when a MAC command is already
+// registered for the given CID and direction.
+func RegisterMACCommand(uplink bool, cid CID, size int, payload func() MACCommandPayload) error {
+	if cid < 0x80 {
+		return fmt.Errorf("lorawan: CID %v is not in the proprietary range (0x80 - 0xFF)", cid)
+	}
+	return DefaultCodec.RegisterMACCommand(uplink, cid, size, payload)
 }
 
 // MACCommandPayload is the interface that every MACCommand payload
@@ -74,42 +139,91 @@ type MACCommandPayload interface {
 
 // MACCommand represents a MAC command with optional payload.
 type MACCommand struct {
-	CID     cid
+	CID     CID
 	Payload MACCommandPayload
 }
 
 // MarshalBinary marshals the object in binary form.
 func (m MACCommand) MarshalBinary() ([]byte, error) {
-	b := []byte{byte(m.CID)}
+	b, err := m.AppendBinary(nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	return b, nil
+}
+
+// AppendBinary appends the object in binary form to b.
+func (m MACCommand) AppendBinary(b []byte) ([]byte, error) {
+	start := len(b)
+	b = append(b, byte(m.CID))
 	if m.Payload != nil {
-		p, err := m.Payload.MarshalBinary()
+		var err error
+		b, err = appendPayload(b, m.Payload)
 		if err != nil {
-			return []byte{}, err
+			return b[:start], err
 		}
-		b = append(b, p...)
 	}
 	return b, nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// UnmarshalBinary decodes the object from binary form. On error, a
+// *DecodeError is returned.
 func (m *MACCommand) UnmarshalBinary(uplink bool, data []byte) error {
+	return DefaultCodec.UnmarshalMACCommand(m, uplink, data)
+}
+
+// unmarshalBinary decodes the object from binary form, using the MAC
+// command registry of the given codec. Unless the codec is strict, an
+// unknown CID results in an UnknownMACCommands payload containing the
+// remaining data.
+func (m *MACCommand) unmarshalBinary(codec *Codec, uplink bool, data []byte) error {
 	if len(data) == 0 {
-		return errors.New("lorawan: at least 1 byte of data is expected")
+		return wrapDecodeError("", 0, newError(ErrTooShort, "lorawan: at least 1 byte of data is expected"))
 	}
-	m.CID = cid(data[0])
+	m.CID = CID(data[0])
+
+	p, _, err := codec.getMACPayloadAndSize(uplink, m.CID)
+	if err != nil {
+		if codec.strict {
+			return wrapDecodeError("CID", 0, err)
+		}
+		m.Payload = &UnknownMACCommands{}
+		return m.Payload.UnmarshalBinary(data[1:])
+	}
+
 	if len(data) > 1 {
-		p, _, err := getMACPayloadAndSize(uplink, m.CID)
-		if err != nil {
-			return err
+		if p == nil {
+			return wrapDecodeError("Payload", 1, newError(ErrInvalidLength, "lorawan: MAC command with CID=%v does not have a payload", m.CID))
 		}
 		m.Payload = p
 		if err := m.Payload.UnmarshalBinary(data[1:]); err != nil {
-			return err
+			return wrapDecodeError("Payload", 1, err)
 		}
 	}
 	return nil
 }
 
+// UnknownMACCommands contains the bytes following the CID of an unknown
+// MAC command. As the size of an unknown MAC command is not known, this
+// contains the payload of the unknown MAC command and all the MAC commands
+// following it. This way these bytes can be logged and are re-marshaled
+// as-is.
+type UnknownMACCommands struct {
+	Bytes []byte
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p UnknownMACCommands) MarshalBinary() ([]byte, error) {
+	return p.Bytes, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *UnknownMACCommands) UnmarshalBinary(data []byte) error {
+	p.Bytes = make([]byte, len(data))
+	copy(p.Bytes, data)
+	return nil
+}
+
 // LinkCheckAnsPayload represents the LinkCheckAns payload.
 type LinkCheckAnsPayload struct {
 	Margin uint8
@@ -124,7 +238,7 @@ func (p LinkCheckAnsPayload) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (p *LinkCheckAnsPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 2 {
-		return errors.New("lorawan: 2 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 2 bytes of data are expected")
 	}
 	p.Margin = uint8(data[0])
 	p.GwCnt = uint8(data[1])
@@ -149,7 +263,7 @@ func (m ChMask) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (m *ChMask) UnmarshalBinary(data []byte) error {
 	if len(data) != 2 {
-		return errors.New("lorawan: 2 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 2 bytes of data are expected")
 	}
 	for i, b := range data {
 		for j := uint8(0); j < 8; j++ {
@@ -165,6 +279,7 @@ func (m *ChMask) UnmarshalBinary(data []byte) error {
 type Redundancy struct {
 	ChMaskCntl uint8
 	NbRep      uint8
+	RFU        uint8 // RFU bit (bit 7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -176,17 +291,18 @@ func (r Redundancy) MarshalBinary() ([]byte, error) {
 	if r.ChMaskCntl > 7 {
 		return b, errors.New("lorawan: max value of ChMaskCntl is 7")
 	}
-	b[0] = r.NbRep ^ (r.ChMaskCntl << 4)
+	b[0] = r.NbRep ^ (r.ChMaskCntl << 4) ^ (r.RFU & 0x80)
 	return b, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (r *Redundancy) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	r.NbRep = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
 	r.ChMaskCntl = (data[0] & ((1 << 6) ^ (1 << 5) ^ (1 << 4))) >> 4
+	r.RFU = data[0] & 0x80
 	return nil
 }
 
@@ -227,7 +343,7 @@ func (p LinkADRReqPayload) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (p *LinkADRReqPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 4 {
-		return errors.New("lorawan: 4 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 4 bytes of data are expected")
 	}
 	p.DataRate = (data[0] & ((1 << 7) ^ (1 << 6) ^ (1 << 5) ^ (1 << 4))) >> 4
 	p.TXPower = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
@@ -246,6 +362,7 @@ type LinkADRAnsPayload struct {
 	ChannelMaskACK bool
 	DataRateACK    bool
 	PowerACK       bool
+	RFU            uint8 // RFU bits (bits 3-7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -260,13 +377,13 @@ func (p LinkADRAnsPayload) MarshalBinary() ([]byte, error) {
 	if p.PowerACK {
 		b = b ^ (1 << 2)
 	}
-	return []byte{b}, nil
+	return []byte{b ^ (p.RFU & 0xf8)}, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *LinkADRAnsPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	if data[0]&(1<<0) > 0 {
 		p.ChannelMaskACK = true
@@ -277,63 +394,86 @@ func (p *LinkADRAnsPayload) UnmarshalBinary(data []byte) error {
 	if data[0]&(1<<2) > 0 {
 		p.PowerACK = true
 	}
+	p.RFU = data[0] & 0xf8
 	return nil
 }
 
 // DutyCycleReqPayload represents the DutyCycleReq payload.
 type DutyCycleReqPayload struct {
 	MaxDCCycle uint8
+	RFU        uint8 // RFU bits (bits 4-7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
 func (p DutyCycleReqPayload) MarshalBinary() ([]byte, error) {
 	b := make([]byte, 0, 1)
-	if p.MaxDCCycle > 15 && p.MaxDCCycle < 255 {
+	if p.MaxDCCycle == 255 {
+		return append(b, p.MaxDCCycle), nil
+	}
+	if p.MaxDCCycle > 15 {
 		return b, errors.New("lorawan: only a MaxDCycle value of 0 - 15 and 255 is allowed")
 	}
-	b = append(b, p.MaxDCCycle)
+	b = append(b, p.MaxDCCycle^(p.RFU&0xf0))
 	return b, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *DutyCycleReqPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	if data[0] == 255 {
+		p.MaxDCCycle, p.RFU = 255, 0
+		return nil
 	}
-	p.MaxDCCycle = data[0]
+	p.MaxDCCycle = data[0] & 0x0f
+	p.RFU = data[0] & 0xf0
 	return nil
 }
 
 // DLsettings represents the DLsettings fields (downlink settings).
 type DLsettings struct {
+	OptNeg      bool // LoRaWAN 1.1 join-accept only, RFU otherwise
 	RX2DataRate uint8
 	RX1DRoffset uint8
+	RFU         uint8 // RFU bit (bit 7) when it is not decoded as OptNeg, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
 func (s DLsettings) MarshalBinary() ([]byte, error) {
-	b := make([]byte, 0, 1)
+	return s.appendBinary(make([]byte, 0, 1))
+}
+
+// appendBinary appends the object in binary form to b.
+func (s DLsettings) appendBinary(b []byte) ([]byte, error) {
 	if s.RX2DataRate > 15 {
 		return b, errors.New("lorawan: max value of RX2DataRate is 15")
 	}
 	if s.RX1DRoffset > 7 {
 		return b, errors.New("lorawan: max value of RX1DRoffset is 7")
 	}
-	b = append(b, s.RX2DataRate^(s.RX1DRoffset<<4))
-	return b, nil
+	v := s.RX2DataRate ^ (s.RX1DRoffset << 4)
+	if s.OptNeg || s.RFU&0x80 != 0 {
+		v = v ^ (1 << 7)
+	}
+	return append(b, v), nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// UnmarshalBinary decodes the object from binary form. Bit 7 is stored in
+// RFU, JoinAcceptPayload decodes it as OptNeg.
 func (s *DLsettings) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	s.RX2DataRate = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
 	s.RX1DRoffset = (data[0] & ((1 << 6) ^ (1 << 5) ^ (1 << 4))) >> 4
+	s.RFU = data[0] & 0x80
 	return nil
 }
 
-// RX2SetupReqPayload represents the RX2SetupReq payload.
+// RX2SetupReqPayload represents the RX2SetupReq payload. Bit 7 of the
+// DLsettings is RFU in the RX2SetupReq, it is stored in DLsettings.RFU and
+// OptNeg is not used.
 type RX2SetupReqPayload struct {
 	Frequency  uint32
 	DLsettings DLsettings
@@ -345,7 +485,9 @@ func (p RX2SetupReqPayload) MarshalBinary() ([]byte, error) {
 	if p.Frequency >= 16777216 { // 2^24
 		return b, errors.New("lorawan: max value of Frequency is 2^24-1")
 	}
-	bytes, err := p.DLsettings.MarshalBinary()
+	s := p.DLsettings
+	s.OptNeg = false
+	bytes, err := s.MarshalBinary()
 	if err != nil {
 		return b, err
 	}
@@ -360,7 +502,7 @@ func (p RX2SetupReqPayload) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (p *RX2SetupReqPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 4 {
-		return errors.New("lorawan: 4 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 4 bytes of data are expected")
 	}
 	if err := p.DLsettings.UnmarshalBinary(data[0:1]); err != nil {
 		return err
@@ -379,6 +521,7 @@ type RX2SetupAnsPayload struct {
 	ChannelACK     bool
 	RX2DataRateACK bool
 	RX1DRoffsetACK bool
+	RFU            uint8 // RFU bits (bits 3-7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -393,17 +536,18 @@ func (p RX2SetupAnsPayload) MarshalBinary() ([]byte, error) {
 	if p.RX1DRoffsetACK {
 		b = b ^ (1 << 2)
 	}
-	return []byte{b}, nil
+	return []byte{b ^ (p.RFU & 0xf8)}, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *RX2SetupAnsPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	p.ChannelACK = data[0]&(1<<0) > 0
 	p.RX2DataRateACK = data[0]&(1<<1) > 0
 	p.RX1DRoffsetACK = data[0]&(1<<2) > 0
+	p.RFU = data[0] & 0xf8
 	return nil
 }
 
@@ -411,6 +555,7 @@ func (p *RX2SetupAnsPayload) UnmarshalBinary(data []byte) error {
 type DevStatusAnsPayload struct {
 	Battery uint8
 	Margin  int8
+	RFU     uint8 // RFU bits (bits 6-7) of the margin byte as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -425,9 +570,9 @@ func (p DevStatusAnsPayload) MarshalBinary() ([]byte, error) {
 
 	b = append(b, p.Battery)
 	if p.Margin < 0 {
-		b = append(b, uint8(64+p.Margin))
+		b = append(b, uint8(64+p.Margin)^(p.RFU&0xc0))
 	} else {
-		b = append(b, uint8(p.Margin))
+		b = append(b, uint8(p.Margin)^(p.RFU&0xc0))
 	}
 	return b, nil
 }
@@ -435,14 +580,16 @@ func (p DevStatusAnsPayload) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (p *DevStatusAnsPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 2 {
-		return errors.New("lorawan: 2 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 2 bytes of data are expected")
 	}
 	p.Battery = data[0]
-	if data[1] > 31 {
-		p.Margin = int8(data[1]) - 64
+	margin := data[1] & 0x3f
+	if margin > 31 {
+		p.Margin = int8(margin) - 64
 	} else {
-		p.Margin = int8(data[1])
+		p.Margin = int8(margin)
 	}
+	p.RFU = data[1] & 0xc0
 	return nil
 }
 
@@ -479,7 +626,7 @@ func (p NewChannelReqPayload) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary decodes the object from binary form.
 func (p *NewChannelReqPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 5 {
-		return errors.New("lorawan: 4 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 5 bytes of data are expected")
 	}
 	p.ChIndex = data[0]
 	p.MinDR = data[4] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
@@ -496,6 +643,7 @@ func (p *NewChannelReqPayload) UnmarshalBinary(data []byte) error {
 type NewChannelAnsPayload struct {
 	ChannelFrequencyOK bool
 	DataRateRangeOK    bool
+	RFU                uint8 // RFU bits (bits 2-7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -507,22 +655,24 @@ func (p NewChannelAnsPayload) MarshalBinary() ([]byte, error) {
 	if p.DataRateRangeOK {
 		b = b ^ (1 << 1)
 	}
-	return []byte{b}, nil
+	return []byte{b ^ (p.RFU & 0xfc)}, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *NewChannelAnsPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	p.ChannelFrequencyOK = data[0]&(1<<0) > 0
 	p.DataRateRangeOK = data[0]&(1<<1) > 0
+	p.RFU = data[0] & 0xfc
 	return nil
 }
 
 // RXTimingSetupReqPayload represents the RXTimingSetupReq payload.
 type RXTimingSetupReqPayload struct {
 	Delay uint8
+	RFU   uint8 // RFU bits (bits 4-7) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
@@ -530,14 +680,591 @@ func (p RXTimingSetupReqPayload) MarshalBinary() ([]byte, error) {
 	if p.Delay > 15 {
 		return []byte{}, errors.New("lorawan: the max value of Delay is 15")
 	}
-	return []byte{p.Delay}, nil
+	return []byte{p.Delay ^ (p.RFU & 0xf0)}, nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *RXTimingSetupReqPayload) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.Delay = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
+	p.RFU = data[0] & 0xf0
+	return nil
+}
+
+// DwellTime defines the dwell time type.
+type DwellTime int
+
+// Possible dwell time options.
+const (
+	DwellTimeNoLimit DwellTime = iota
+	DwellTime400ms
+)
+
+// maxEIRPTable contains the MaxEIRP values (dBm) by their index as used
+// by the TXParamSetupReq.
+var maxEIRPTable = [16]uint8{8, 10, 12, 13, 14, 16, 18, 20, 21, 24, 26, 27, 29, 30, 33, 36}
+
+// TXParamSetupReqPayload represents the TXParamSetupReq payload.
+type TXParamSetupReqPayload struct {
+	DownlinkDwellTime DwellTime
+	UplinkDwellTime   DwellTime
+	MaxEIRP           uint8 // dBm, must be one of 8, 10, 12, 13, 14, 16, 18, 20, 21, 24, 26, 27, 29, 30, 33, 36
+	RFU               uint8 // RFU bits (bits 6-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p TXParamSetupReqPayload) MarshalBinary() ([]byte, error) {
+	b := []byte{0}
+	if p.DownlinkDwellTime != DwellTimeNoLimit && p.DownlinkDwellTime != DwellTime400ms {
+		return []byte{}, errors.New("lorawan: invalid DownlinkDwellTime value")
+	}
+	if p.UplinkDwellTime != DwellTimeNoLimit && p.UplinkDwellTime != DwellTime400ms {
+		return []byte{}, errors.New("lorawan: invalid UplinkDwellTime value")
+	}
+
+	eirp := -1
+	for i, v := range maxEIRPTable {
+		if v == p.MaxEIRP {
+			eirp = i
+			break
+		}
+	}
+	if eirp == -1 {
+		return []byte{}, fmt.Errorf("lorawan: invalid MaxEIRP value %d", p.MaxEIRP)
+	}
+
+	b[0] = uint8(eirp) ^ (p.RFU & 0xc0)
+	if p.UplinkDwellTime == DwellTime400ms {
+		b[0] = b[0] ^ (1 << 4)
+	}
+	if p.DownlinkDwellTime == DwellTime400ms {
+		b[0] = b[0] ^ (1 << 5)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *TXParamSetupReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.MaxEIRP = maxEIRPTable[data[0]&((1<<3)^(1<<2)^(1<<1)^(1<<0))]
+	p.UplinkDwellTime = DwellTimeNoLimit
+	if data[0]&(1<<4) > 0 {
+		p.UplinkDwellTime = DwellTime400ms
+	}
+	p.DownlinkDwellTime = DwellTimeNoLimit
+	if data[0]&(1<<5) > 0 {
+		p.DownlinkDwellTime = DwellTime400ms
+	}
+	p.RFU = data[0] & 0xc0
+	return nil
+}
+
+// DLChannelReqPayload represents the DLChannelReq payload.
+type DLChannelReqPayload struct {
+	ChIndex uint8
+	Freq    uint32
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p DLChannelReqPayload) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 5)
+	if p.Freq >= 16777216 { // 2^24
+		return b[0:4], errors.New("lorawan: max value of Freq is 2^24 - 1")
+	}
+
+	// we're borrowing the last byte b[4] because PutUint32 needs 4 bytes,
+	// the last byte will be 0 because max Freq = 2^24 - 1
+	binary.LittleEndian.PutUint32(b[1:5], p.Freq)
+	b[0] = p.ChIndex
+
+	return b[0:4], nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *DLChannelReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return newError(ErrInvalidLength, "lorawan: 4 bytes of data are expected")
+	}
+	p.ChIndex = data[0]
+
+	b := make([]byte, 4)
+	copy(b, data[1:4])
+	p.Freq = binary.LittleEndian.Uint32(b)
+	return nil
+}
+
+// DLChannelAnsPayload represents the DLChannelAns payload.
+type DLChannelAnsPayload struct {
+	UplinkFrequencyExists bool
+	ChannelFrequencyOK    bool
+	RFU                   uint8 // RFU bits (bits 2-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p DLChannelAnsPayload) MarshalBinary() ([]byte, error) {
+	var b byte
+	if p.ChannelFrequencyOK {
+		b = b ^ (1 << 0)
+	}
+	if p.UplinkFrequencyExists {
+		b = b ^ (1 << 1)
+	}
+	return []byte{b ^ (p.RFU & 0xfc)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *DLChannelAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.ChannelFrequencyOK = data[0]&(1<<0) > 0
+	p.UplinkFrequencyExists = data[0]&(1<<1) > 0
+	p.RFU = data[0] & 0xfc
+	return nil
+}
+
+// DeviceTimeAnsPayload represents the DeviceTimeAns payload.
+type DeviceTimeAnsPayload struct {
+	GPSEpochSeconds   uint32 // seconds since the GPS epoch
+	FractionalSeconds uint8  // in 1/256 seconds
+}
+
+// NewDeviceTimeAnsPayload returns the DeviceTimeAns payload for the given
+// time. The fractional seconds are rounded down to 1/256 seconds.
+func NewDeviceTimeAnsPayload(t time.Time) DeviceTimeAnsPayload {
+	d := TimeToGPSEpoch(t)
+	return DeviceTimeAnsPayload{
+		GPSEpochSeconds:   uint32(d / time.Second),
+		FractionalSeconds: uint8((d % time.Second) * 256 / time.Second),
+	}
+}
+
+// TimeSinceGPSEpoch returns the time since the GPS epoch.
+func (p DeviceTimeAnsPayload) TimeSinceGPSEpoch() time.Duration {
+	return time.Duration(p.GPSEpochSeconds)*time.Second + time.Duration(p.FractionalSeconds)*time.Second/256
+}
+
+// Time returns the time (corrected for leap seconds).
+func (p DeviceTimeAnsPayload) Time() time.Time {
+	return GPSEpochToTime(p.TimeSinceGPSEpoch())
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p DeviceTimeAnsPayload) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 5)
+	binary.LittleEndian.PutUint32(b[0:4], p.GPSEpochSeconds)
+	b[4] = p.FractionalSeconds
+	return b, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *DeviceTimeAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 5 {
+		return newError(ErrInvalidLength, "lorawan: 5 bytes of data are expected")
+	}
+	p.GPSEpochSeconds = binary.LittleEndian.Uint32(data[0:4])
+	p.FractionalSeconds = data[4]
+	return nil
+}
+
+// Version defines the LoRaWAN version field as used by the ResetInd,
+// ResetConf, RekeyInd and RekeyConf MAC commands.
+type Version struct {
+	Minor uint8 // 1 = LoRaWAN 1.1
+	RFU   uint8 // RFU bits (bits 4-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (v Version) MarshalBinary() ([]byte, error) {
+	if v.Minor > 15 {
+		return []byte{}, errors.New("lorawan: max value of Minor is 15")
+	}
+	return []byte{v.Minor ^ (v.RFU & 0xf0)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (v *Version) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	v.Minor = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
+	v.RFU = data[0] & 0xf0
+	return nil
+}
+
+// ResetIndPayload represents the ResetInd payload.
+type ResetIndPayload struct {
+	DevLoRaWANVersion Version
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p ResetIndPayload) MarshalBinary() ([]byte, error) {
+	return p.DevLoRaWANVersion.MarshalBinary()
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *ResetIndPayload) UnmarshalBinary(data []byte) error {
+	return p.DevLoRaWANVersion.UnmarshalBinary(data)
+}
+
+// ResetConfPayload represents the ResetConf payload.
+type ResetConfPayload struct {
+	ServLoRaWANVersion Version
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p ResetConfPayload) MarshalBinary() ([]byte, error) {
+	return p.ServLoRaWANVersion.MarshalBinary()
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *ResetConfPayload) UnmarshalBinary(data []byte) error {
+	return p.ServLoRaWANVersion.UnmarshalBinary(data)
+}
+
+// RekeyIndPayload represents the RekeyInd payload.
+type RekeyIndPayload struct {
+	DevLoRaWANVersion Version
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RekeyIndPayload) MarshalBinary() ([]byte, error) {
+	return p.DevLoRaWANVersion.MarshalBinary()
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RekeyIndPayload) UnmarshalBinary(data []byte) error {
+	return p.DevLoRaWANVersion.UnmarshalBinary(data)
+}
+
+// RekeyConfPayload represents the RekeyConf payload.
+type RekeyConfPayload struct {
+	ServLoRaWANVersion Version
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RekeyConfPayload) MarshalBinary() ([]byte, error) {
+	return p.ServLoRaWANVersion.MarshalBinary()
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RekeyConfPayload) UnmarshalBinary(data []byte) error {
+	return p.ServLoRaWANVersion.UnmarshalBinary(data)
+}
+
+// ADRParamSetupReqPayload represents the ADRParamSetupReq payload.
+type ADRParamSetupReqPayload struct {
+	LimitExp uint8 // ADR_ACK_LIMIT = 2^LimitExp
+	DelayExp uint8 // ADR_ACK_DELAY = 2^DelayExp
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p ADRParamSetupReqPayload) MarshalBinary() ([]byte, error) {
+	if p.LimitExp > 15 {
+		return []byte{}, errors.New("lorawan: max value of LimitExp is 15")
+	}
+	if p.DelayExp > 15 {
+		return []byte{}, errors.New("lorawan: max value of DelayExp is 15")
+	}
+	return []byte{p.DelayExp ^ (p.LimitExp << 4)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *ADRParamSetupReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.DelayExp = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
+	p.LimitExp = (data[0] & ((1 << 7) ^ (1 << 6) ^ (1 << 5) ^ (1 << 4))) >> 4
+	return nil
+}
+
+// ForceRejoinReqPayload represents the ForceRejoinReq payload.
+type ForceRejoinReqPayload struct {
+	Period     uint8 // delay between retransmissions = 32s * 2^Period + rand(32s)
+	MaxRetries uint8
+	RejoinType RejoinType // 0 and 1 = rejoin-request type 0, 2 = rejoin-request type 2
+	DR         uint8
+	RFU        uint16 // RFU bits (bits 7, 14 and 15) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p ForceRejoinReqPayload) MarshalBinary() ([]byte, error) {
+	if p.Period > 7 {
+		return []byte{}, errors.New("lorawan: max value of Period is 7")
+	}
+	if p.MaxRetries > 7 {
+		return []byte{}, errors.New("lorawan: max value of MaxRetries is 7")
+	}
+	if p.RejoinType > RejoinRequestType2 {
+		return []byte{}, errors.New("lorawan: max value of RejoinType is 2")
+	}
+	if p.DR > 15 {
+		return []byte{}, errors.New("lorawan: max value of DR is 15")
+	}
+
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(p.DR)^uint16(p.RejoinType)<<4^uint16(p.MaxRetries)<<8^uint16(p.Period)<<11^(p.RFU&0xc080))
+	return b, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *ForceRejoinReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return newError(ErrInvalidLength, "lorawan: 2 bytes of data are expected")
+	}
+	v := binary.LittleEndian.Uint16(data)
+	p.DR = uint8(v & 0x0f)
+	p.RejoinType = RejoinType((v >> 4) & 0x07)
+	p.MaxRetries = uint8((v >> 8) & 0x07)
+	p.Period = uint8((v >> 11) & 0x07)
+	p.RFU = v & 0xc080
+	return nil
+}
+
+// RejoinParamSetupReqPayload represents the RejoinParamSetupReq payload.
+type RejoinParamSetupReqPayload struct {
+	MaxTimeN  uint8 // max time between rejoin-requests = 2^(MaxTimeN+10) seconds
+	MaxCountN uint8 // max number of uplinks between rejoin-requests = 2^(MaxCountN+4)
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RejoinParamSetupReqPayload) MarshalBinary() ([]byte, error) {
+	if p.MaxTimeN > 15 {
+		return []byte{}, errors.New("lorawan: max value of MaxTimeN is 15")
+	}
+	if p.MaxCountN > 15 {
+		return []byte{}, errors.New("lorawan: max value of MaxCountN is 15")
+	}
+	return []byte{p.MaxCountN ^ (p.MaxTimeN << 4)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RejoinParamSetupReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.MaxCountN = data[0] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
+	p.MaxTimeN = (data[0] & ((1 << 7) ^ (1 << 6) ^ (1 << 5) ^ (1 << 4))) >> 4
+	return nil
+}
+
+// RejoinParamSetupAnsPayload represents the RejoinParamSetupAns payload.
+type RejoinParamSetupAnsPayload struct {
+	TimeOK bool
+	RFU    uint8 // RFU bits (bits 1-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RejoinParamSetupAnsPayload) MarshalBinary() ([]byte, error) {
+	var b byte
+	if p.TimeOK {
+		b = b ^ (1 << 0)
+	}
+	return []byte{b ^ (p.RFU & 0xfe)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RejoinParamSetupAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.TimeOK = data[0]&(1<<0) > 0
+	p.RFU = data[0] & 0xfe
+	return nil
+}
+
+// PingSlotInfoReqPayload represents the PingSlotInfoReq payload.
+type PingSlotInfoReqPayload struct {
+	Periodicity uint8 // ping period = 2^Periodicity seconds
+	RFU         uint8 // RFU bits (bits 3-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p PingSlotInfoReqPayload) MarshalBinary() ([]byte, error) {
+	if p.Periodicity > 7 {
+		return []byte{}, errors.New("lorawan: max value of Periodicity is 7")
+	}
+	return []byte{p.Periodicity ^ (p.RFU & 0xf8)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *PingSlotInfoReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.Periodicity = data[0] & ((1 << 2) ^ (1 << 1) ^ (1 << 0))
+	p.RFU = data[0] & 0xf8
+	return nil
+}
+
+// validateClassBFrequency returns an error when the given frequency is not
+// 0 (the default frequency) and outside the given range. All values are in
+// units of 100 Hz.
+func validateClassBFrequency(f, minFrequency, maxFrequency uint32) error {
+	if f != 0 && (f < minFrequency || f > maxFrequency) {
+		return newError(ErrValueOutOfRange, "lorawan: Frequency must be 0 or between %d and %d (x 100 Hz)", minFrequency, maxFrequency)
+	}
+	return nil
+}
+
+// PingSlotChannelReqPayload represents the PingSlotChannelReq payload.
+type PingSlotChannelReqPayload struct {
+	Frequency uint32 // in units of 100 Hz, 0 = default ping-slot frequency
+	DR        uint8
+	RFU       uint8 // RFU bits (bits 4-7) of the DR byte as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p PingSlotChannelReqPayload) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4)
+	if p.Frequency >= 16777216 { // 2^24
+		return []byte{}, errors.New("lorawan: max value of Frequency is 2^24 - 1")
+	}
+	if p.DR > 15 {
+		return []byte{}, errors.New("lorawan: max value of DR is 15")
+	}
+
+	// the last byte b[3] will be 0 because max Frequency = 2^24 - 1
+	binary.LittleEndian.PutUint32(b, p.Frequency)
+	b[3] = p.DR ^ (p.RFU & 0xf0)
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *PingSlotChannelReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return newError(ErrInvalidLength, "lorawan: 4 bytes of data are expected")
+	}
+	p.DR = data[3] & ((1 << 3) ^ (1 << 2) ^ (1 << 1) ^ (1 << 0))
+	p.RFU = data[3] & 0xf0
+
+	b := make([]byte, 4)
+	copy(b, data[0:3])
+	p.Frequency = binary.LittleEndian.Uint32(b)
+	return nil
+}
+
+// Validate returns an error when the Frequency is not 0 and outside the
+// frequency range of the band, given in units of 100 Hz. A device answers
+// such request with ChannelFrequencyOK = false.
+func (p PingSlotChannelReqPayload) Validate(minFrequency, maxFrequency uint32) error {
+	return validateClassBFrequency(p.Frequency, minFrequency, maxFrequency)
+}
+
+// PingSlotChannelAnsPayload represents the PingSlotChannelAns payload.
+type PingSlotChannelAnsPayload struct {
+	DataRateOK         bool
+	ChannelFrequencyOK bool
+	RFU                uint8 // RFU bits (bits 2-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p PingSlotChannelAnsPayload) MarshalBinary() ([]byte, error) {
+	var b byte
+	if p.ChannelFrequencyOK {
+		b = b ^ (1 << 0)
+	}
+	if p.DataRateOK {
+		b = b ^ (1 << 1)
+	}
+	return []byte{b ^ (p.RFU & 0xfc)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *PingSlotChannelAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
+	}
+	p.ChannelFrequencyOK = data[0]&(1<<0) > 0
+	p.DataRateOK = data[0]&(1<<1) > 0
+	p.RFU = data[0] & 0xfc
+	return nil
+}
+
+// BeaconTimingAnsPayload represents the BeaconTimingAns payload.
+type BeaconTimingAnsPayload struct {
+	Delay   uint16 // time till the next beacon = 30ms * (Delay + 1)
+	Channel uint8
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p BeaconTimingAnsPayload) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 3)
+	binary.LittleEndian.PutUint16(b[0:2], p.Delay)
+	b[2] = p.Channel
+	return b, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *BeaconTimingAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return newError(ErrInvalidLength, "lorawan: 3 bytes of data are expected")
+	}
+	p.Delay = binary.LittleEndian.Uint16(data[0:2])
+	p.Channel = data[2]
+	return nil
+}
+
+// BeaconFreqReqPayload represents the BeaconFreqReq payload.
+type BeaconFreqReqPayload struct {
+	Frequency uint32 // in units of 100 Hz, 0 = default beacon frequency
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p BeaconFreqReqPayload) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4)
+	if p.Frequency >= 16777216 { // 2^24
+		return []byte{}, errors.New("lorawan: max value of Frequency is 2^24 - 1")
+	}
+	binary.LittleEndian.PutUint32(b, p.Frequency)
+	return b[0:3], nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *BeaconFreqReqPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return newError(ErrInvalidLength, "lorawan: 3 bytes of data are expected")
+	}
+	b := make([]byte, 4)
+	copy(b, data)
+	p.Frequency = binary.LittleEndian.Uint32(b)
+	return nil
+}
+
+// Validate returns an error when the Frequency is not 0 and outside the
+// frequency range of the band, given in units of 100 Hz. A device answers
+// such request with BeaconFrequencyOK = false.
+func (p BeaconFreqReqPayload) Validate(minFrequency, maxFrequency uint32) error {
+	return validateClassBFrequency(p.Frequency, minFrequency, maxFrequency)
+}
+
+// BeaconFreqAnsPayload represents the BeaconFreqAns payload.
+type BeaconFreqAnsPayload struct {
+	BeaconFrequencyOK bool
+	RFU               uint8 // RFU bits (bits 1-7) as received, preserved when re-marshaling
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p BeaconFreqAnsPayload) MarshalBinary() ([]byte, error) {
+	var b byte
+	if p.BeaconFrequencyOK {
+		b = b ^ (1 << 0)
+	}
+	return []byte{b ^ (p.RFU & 0xfe)}, nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *BeaconFreqAnsPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
-	p.Delay = data[0]
+	p.BeaconFrequencyOK = data[0]&(1<<0) > 0
+	p.RFU = data[0] & 0xfe
 	return nil
 }
@@ -1,8 +1,10 @@
 package lorawan
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -40,6 +42,67 @@ func TestGetMACPayloadAndSize(t *testing.T) {
 	})
 }
 
+// testProprietaryMACPayload is a proprietary MAC command payload used for
+// testing RegisterMACCommand.
+type testProprietaryMACPayload struct {
+	Value uint16
+}
+
+func (p testProprietaryMACPayload) MarshalBinary() ([]byte, error) {
+	return []byte{byte(p.Value), byte(p.Value >> 8)}, nil
+}
+
+func (p *testProprietaryMACPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return errors.New("lorawan: 2 bytes of data are expected")
+	}
+	p.Value = uint16(data[0]) | uint16(data[1])<<8
+	return nil
+}
+
+func TestRegisterMACCommand(t *testing.T) {
+	Convey("Given a proprietary uplink MAC command with CID=0x80 and a payload of 2 bytes", t, func() {
+		newPayload := func() MACCommandPayload { return &testProprietaryMACPayload{} }
+		So(RegisterMACCommand(true, 0x80, 2, newPayload), ShouldBeNil)
+		defer func() {
+			DefaultCodec.mu.Lock()
+			delete(DefaultCodec.macCommands[true], 0x80)
+			DefaultCodec.mu.Unlock()
+		}()
+
+		Convey("Then registering it again returns an error", func() {
+			So(RegisterMACCommand(true, 0x80, 2, newPayload), ShouldResemble, errors.New("lorawan: MAC command already registered for uplink=true and CID=128"))
+		})
+
+		Convey("Then registering a non-proprietary CID returns an error", func() {
+			So(RegisterMACCommand(true, 0x10, 2, newPayload), ShouldResemble, errors.New("lorawan: CID 16 is not in the proprietary range (0x80 - 0xFF)"))
+		})
+
+		Convey("Then the MAC command is not registered for downlink", func() {
+			_, _, err := getMACPayloadAndSize(false, 0x80)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then it is decoded as part of the FRMPayload (FPort=0)", func() {
+			var macPL MACPayload
+			So(macPL.UnmarshalBinary(true, []byte{4, 3, 2, 1, 0, 1, 0, 0, 0x80, 0x34, 0x12, 0x02}), ShouldBeNil)
+			So(macPL.FRMPayload, ShouldResemble, []Payload{
+				&MACCommand{CID: 0x80, Payload: &testProprietaryMACPayload{Value: 0x1234}},
+				&MACCommand{CID: LinkCheckReq},
+			})
+		})
+
+		Convey("Then it is decoded as part of the FOpts", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(true, []byte{4, 3, 2, 1, 4, 1, 0, 0x02, 0x80, 0x34, 0x12}), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: LinkCheckReq},
+				{CID: 0x80, Payload: &testProprietaryMACPayload{Value: 0x1234}},
+			})
+		})
+	})
+}
+
 func TestMACCommand(t *testing.T) {
 	Convey("Given an empty MACCommand", t, func() {
 		var m MACCommand
@@ -351,7 +414,7 @@ func TestDutyCycleReqPayload(t *testing.T) {
 			Convey("Then UnmarshalBinary returns a DutyCycleReqPayload with MaxDCCycle=13", func() {
 				err := p.UnmarshalBinary(b)
 				So(err, ShouldBeNil)
-				So(p, ShouldResemble, DutyCycleReqPayload{13})
+				So(p, ShouldResemble, DutyCycleReqPayload{MaxDCCycle: 13})
 			})
 		})
 	})
@@ -400,6 +463,25 @@ func TestDLsettings(t *testing.T) {
 				So(s, ShouldResemble, DLsettings{RX2DataRate: 15, RX1DRoffset: 7})
 			})
 		})
+
+		Convey("Given OptNeg=true and RX2DataRate=3", func() {
+			s.OptNeg = true
+			s.RX2DataRate = 3
+			Convey("Then MarshalBinary returns []byte{131}", func() {
+				b, err := s.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, []byte{131})
+			})
+		})
+
+		Convey("Given a slice []byte{131}", func() {
+			b := []byte{131}
+			Convey("Then UnmarshalBinary returns a DLsettings with RFU=0x80 and RX2DataRate=3", func() {
+				err := s.UnmarshalBinary(b)
+				So(err, ShouldBeNil)
+				So(s, ShouldResemble, DLsettings{RX2DataRate: 3, RFU: 0x80})
+			})
+		})
 	})
 }
 
@@ -442,6 +524,18 @@ func TestRX2SetupReqPayload(t *testing.T) {
 				So(p, ShouldResemble, exp)
 			})
 		})
+
+		Convey("Given a slice []byte{187, 1, 2, 4} with the RFU bit 7 set", func() {
+			b := []byte{187, 1, 2, 4}
+			Convey("Then UnmarshalBinary stores bit 7 as RFU and not as OptNeg", func() {
+				So(p.UnmarshalBinary(b), ShouldBeNil)
+				So(p.DLsettings, ShouldResemble, DLsettings{RX2DataRate: 11, RX1DRoffset: 3, RFU: 0x80})
+
+				out, err := p.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(out, ShouldResemble, b)
+			})
+		})
 	})
 }
 
@@ -662,3 +756,313 @@ func TestRXTimingSetupReqPayload(t *testing.T) {
 		})
 	})
 }
+
+func TestTXParamSetupReqPayload(t *testing.T) {
+	Convey("Given a set of test for TXParamSetupReqPayload", t, func() {
+		testTable := []struct {
+			Payload TXParamSetupReqPayload
+			Bytes   []byte
+			Error   error
+		}{
+			{TXParamSetupReqPayload{MaxEIRP: 8}, []byte{0}, nil},
+			{TXParamSetupReqPayload{MaxEIRP: 36}, []byte{15}, nil},
+			{TXParamSetupReqPayload{UplinkDwellTime: DwellTime400ms, MaxEIRP: 16}, []byte{0x15}, nil},
+			{TXParamSetupReqPayload{DownlinkDwellTime: DwellTime400ms, MaxEIRP: 16}, []byte{0x25}, nil},
+			{TXParamSetupReqPayload{DownlinkDwellTime: DwellTime400ms, UplinkDwellTime: DwellTime400ms, MaxEIRP: 30}, []byte{0x3d}, nil},
+			{TXParamSetupReqPayload{MaxEIRP: 15}, nil, errors.New("lorawan: invalid MaxEIRP value 15")},
+			{TXParamSetupReqPayload{UplinkDwellTime: 2, MaxEIRP: 8}, nil, errors.New("lorawan: invalid UplinkDwellTime value")},
+		}
+
+		for _, test := range testTable {
+			Convey(fmt.Sprintf("Given %+v", test.Payload), func() {
+				b, err := test.Payload.MarshalBinary()
+				if test.Error != nil {
+					Convey("Then MarshalBinary returns an error", func() {
+						So(err, ShouldResemble, test.Error)
+					})
+					return
+				}
+
+				Convey(fmt.Sprintf("Then MarshalBinary returns %v", test.Bytes), func() {
+					So(err, ShouldBeNil)
+					So(b, ShouldResemble, test.Bytes)
+				})
+
+				Convey("Then UnmarshalBinary returns the same payload", func() {
+					var p TXParamSetupReqPayload
+					So(p.UnmarshalBinary(test.Bytes), ShouldBeNil)
+					So(p, ShouldResemble, test.Payload)
+				})
+			})
+		}
+	})
+}
+
+func TestDLChannelReqPayload(t *testing.T) {
+	Convey("Given a DLChannelReqPayload with ChIndex=3 and Freq=8671000", t, func() {
+		p := DLChannelReqPayload{ChIndex: 3, Freq: 8671000}
+
+		Convey("Then MarshalBinary returns []byte{3, 24, 79, 132}", func() {
+			b, err := p.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{3, 24, 79, 132})
+
+			Convey("Then UnmarshalBinary returns the same payload", func() {
+				var out DLChannelReqPayload
+				So(out.UnmarshalBinary(b), ShouldBeNil)
+				So(out, ShouldResemble, p)
+			})
+		})
+
+		Convey("Given Freq > 2^24 - 1", func() {
+			p.Freq = 16777216
+			Convey("Then MarshalBinary returns an error", func() {
+				_, err := p.MarshalBinary()
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestDLChannelAnsPayload(t *testing.T) {
+	Convey("Given a set of tests for DLChannelAnsPayload", t, func() {
+		testTable := []struct {
+			Payload DLChannelAnsPayload
+			Bytes   []byte
+		}{
+			{DLChannelAnsPayload{}, []byte{0}},
+			{DLChannelAnsPayload{ChannelFrequencyOK: true}, []byte{1}},
+			{DLChannelAnsPayload{UplinkFrequencyExists: true}, []byte{2}},
+			{DLChannelAnsPayload{UplinkFrequencyExists: true, ChannelFrequencyOK: true}, []byte{3}},
+		}
+
+		for _, test := range testTable {
+			Convey(fmt.Sprintf("Then %+v marshals to %v and back", test.Payload, test.Bytes), func() {
+				b, err := test.Payload.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, test.Bytes)
+
+				var p DLChannelAnsPayload
+				So(p.UnmarshalBinary(b), ShouldBeNil)
+				So(p, ShouldResemble, test.Payload)
+			})
+		}
+	})
+}
+
+func TestRegionalMACCommandsInFOpts(t *testing.T) {
+	Convey("Given downlink FOpts containing a NewChannelReq, TXParamSetupReq and DLChannelReq", t, func() {
+		b := []byte{4, 3, 2, 1, 13, 0, 0, 0x07, 3, 1, 2, 4, 90, 0x09, 0x3d, 0x0a, 3, 24, 79, 132}
+
+		Convey("Then UnmarshalBinary decodes the MAC commands", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(false, b), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: NewChannelReq, Payload: &NewChannelReqPayload{ChIndex: 3, Freq: 262657, MaxDR: 5, MinDR: 10}},
+				{CID: TXParamSetupReq, Payload: &TXParamSetupReqPayload{DownlinkDwellTime: DwellTime400ms, UplinkDwellTime: DwellTime400ms, MaxEIRP: 30}},
+				{CID: DLChannelReq, Payload: &DLChannelReqPayload{ChIndex: 3, Freq: 8671000}},
+			})
+
+			Convey("Then MarshalBinary returns the original bytes", func() {
+				out, err := h.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(out, ShouldResemble, b)
+			})
+		})
+	})
+}
+
+func TestDeviceTimeAnsPayload(t *testing.T) {
+	Convey("Given the time 2025-05-01T12:00:00.5Z", t, func() {
+		ts := time.Date(2025, time.May, 1, 12, 0, 0, 500000000, time.UTC)
+
+		Convey("Then NewDeviceTimeAnsPayload returns the GPS epoch seconds and fractional seconds", func() {
+			p := NewDeviceTimeAnsPayload(ts)
+			So(p, ShouldResemble, DeviceTimeAnsPayload{GPSEpochSeconds: 1430136018, FractionalSeconds: 128})
+			So(p.TimeSinceGPSEpoch(), ShouldEqual, 1430136018*time.Second+500*time.Millisecond)
+			So(p.Time().Equal(ts), ShouldBeTrue)
+
+			Convey("Then MarshalBinary returns []byte{210, 36, 62, 85, 128}", func() {
+				b, err := p.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, []byte{210, 36, 62, 85, 128})
+
+				Convey("Then UnmarshalBinary returns the same payload", func() {
+					var out DeviceTimeAnsPayload
+					So(out.UnmarshalBinary(b), ShouldBeNil)
+					So(out, ShouldResemble, p)
+				})
+
+				Convey("Then it is decoded as part of the downlink FOpts", func() {
+					var h FHDR
+					So(h.UnmarshalBinary(false, append([]byte{4, 3, 2, 1, 6, 0, 0, 0x0d}, b...)), ShouldBeNil)
+					So(h.FOpts, ShouldResemble, []MACCommand{
+						{CID: DeviceTimeAns, Payload: &p},
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestLoRaWAN11SessionMACCommands(t *testing.T) {
+	Convey("Given a set of tests for the LoRaWAN 1.1 session management MAC commands", t, func() {
+		testTable := []struct {
+			Name    string
+			CID     CID
+			Uplink  bool
+			Payload MACCommandPayload
+			Bytes   []byte
+			Error   error
+		}{
+			{"ResetInd", ResetInd, true, &ResetIndPayload{DevLoRaWANVersion: Version{Minor: 1}}, []byte{0x01}, nil},
+			{"ResetConf", ResetConf, false, &ResetConfPayload{ServLoRaWANVersion: Version{Minor: 1}}, []byte{0x01}, nil},
+			{"RekeyInd", RekeyInd, true, &RekeyIndPayload{DevLoRaWANVersion: Version{Minor: 1}}, []byte{0x01}, nil},
+			{"RekeyConf", RekeyConf, false, &RekeyConfPayload{ServLoRaWANVersion: Version{Minor: 16}}, nil, errors.New("lorawan: max value of Minor is 15")},
+			{"ADRParamSetupReq", ADRParamSetupReq, false, &ADRParamSetupReqPayload{LimitExp: 6, DelayExp: 5}, []byte{0x65}, nil},
+			{"ADRParamSetupReq", ADRParamSetupReq, false, &ADRParamSetupReqPayload{LimitExp: 16}, nil, errors.New("lorawan: max value of LimitExp is 15")},
+			{"ForceRejoinReq", ForceRejoinReq, false, &ForceRejoinReqPayload{Period: 3, MaxRetries: 4, RejoinType: RejoinRequestType2, DR: 5}, []byte{0x25, 0x1c}, nil},
+			{"ForceRejoinReq", ForceRejoinReq, false, &ForceRejoinReqPayload{Period: 7, MaxRetries: 7, DR: 15}, []byte{0x0f, 0x3f}, nil},
+			{"ForceRejoinReq", ForceRejoinReq, false, &ForceRejoinReqPayload{RejoinType: RejoinRequestType1}, []byte{0x10, 0x00}, nil},
+			{"ForceRejoinReq", ForceRejoinReq, false, &ForceRejoinReqPayload{RejoinType: 3}, nil, errors.New("lorawan: max value of RejoinType is 2")},
+			{"ForceRejoinReq", ForceRejoinReq, false, &ForceRejoinReqPayload{Period: 8}, nil, errors.New("lorawan: max value of Period is 7")},
+			{"RejoinParamSetupReq", RejoinParamSetupReq, false, &RejoinParamSetupReqPayload{MaxTimeN: 14, MaxCountN: 3}, []byte{0xe3}, nil},
+			{"RejoinParamSetupAns", RejoinParamSetupAns, true, &RejoinParamSetupAnsPayload{TimeOK: true}, []byte{0x01}, nil},
+		}
+
+		for i, test := range testTable {
+			Convey(fmt.Sprintf("Testing: %s [%d]", test.Name, i), func() {
+				b, err := test.Payload.MarshalBinary()
+				if test.Error != nil {
+					So(err, ShouldResemble, test.Error)
+					return
+				}
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, test.Bytes)
+
+				var mac MACCommand
+				So(mac.UnmarshalBinary(test.Uplink, append([]byte{byte(test.CID)}, b...)), ShouldBeNil)
+				So(mac.Payload, ShouldResemble, test.Payload)
+			})
+		}
+	})
+
+	Convey("Given uplink FOpts containing a ResetInd, RekeyInd and RejoinParamSetupAns", t, func() {
+		b := []byte{4, 3, 2, 1, 6, 0, 0, 0x01, 0x01, 0x0b, 0x01, 0x0f, 0x01}
+
+		Convey("Then UnmarshalBinary decodes the MAC commands", func() {
+			var h FHDR
+			So(h.UnmarshalBinary(true, b), ShouldBeNil)
+			So(h.FOpts, ShouldResemble, []MACCommand{
+				{CID: ResetInd, Payload: &ResetIndPayload{DevLoRaWANVersion: Version{Minor: 1}}},
+				{CID: RekeyInd, Payload: &RekeyIndPayload{DevLoRaWANVersion: Version{Minor: 1}}},
+				{CID: RejoinParamSetupAns, Payload: &RejoinParamSetupAnsPayload{TimeOK: true}},
+			})
+		})
+	})
+}
+
+func TestClassBMACCommands(t *testing.T) {
+	Convey("Given a set of tests for the Class B MAC commands", t, func() {
+		testTable := []struct {
+			Name    string
+			CID     CID
+			Uplink  bool
+			Payload MACCommandPayload
+			Bytes   []byte
+			Error   error
+		}{
+			{"PingSlotInfoReq", PingSlotInfoReq, true, &PingSlotInfoReqPayload{Periodicity: 5}, []byte{0x05}, nil},
+			{"PingSlotInfoReq", PingSlotInfoReq, true, &PingSlotInfoReqPayload{Periodicity: 8}, nil, errors.New("lorawan: max value of Periodicity is 7")},
+			{"PingSlotChannelReq", PingSlotChannelReq, false, &PingSlotChannelReqPayload{Frequency: 8671000, DR: 3}, []byte{24, 79, 132, 3}, nil},
+			{"PingSlotChannelReq", PingSlotChannelReq, false, &PingSlotChannelReqPayload{Frequency: 0, DR: 3}, []byte{0, 0, 0, 3}, nil},
+			{"PingSlotChannelReq", PingSlotChannelReq, false, &PingSlotChannelReqPayload{Frequency: 16777216}, nil, errors.New("lorawan: max value of Frequency is 2^24 - 1")},
+			{"PingSlotChannelReq", PingSlotChannelReq, false, &PingSlotChannelReqPayload{Frequency: 8671000, DR: 16}, nil, errors.New("lorawan: max value of DR is 15")},
+			{"PingSlotChannelAns", PingSlotChannelAns, true, &PingSlotChannelAnsPayload{DataRateOK: true, ChannelFrequencyOK: true}, []byte{0x03}, nil},
+			{"PingSlotChannelAns", PingSlotChannelAns, true, &PingSlotChannelAnsPayload{DataRateOK: true}, []byte{0x02}, nil},
+			{"BeaconTimingAns", BeaconTimingAns, false, &BeaconTimingAnsPayload{Delay: 1000, Channel: 2}, []byte{0xe8, 0x03, 0x02}, nil},
+			{"BeaconFreqReq", BeaconFreqReq, false, &BeaconFreqReqPayload{Frequency: 8695250}, []byte{0xd2, 0xad, 0x84}, nil},
+			{"BeaconFreqReq", BeaconFreqReq, false, &BeaconFreqReqPayload{Frequency: 0}, []byte{0, 0, 0}, nil},
+			{"BeaconFreqReq", BeaconFreqReq, false, &BeaconFreqReqPayload{Frequency: 16777216}, nil, errors.New("lorawan: max value of Frequency is 2^24 - 1")},
+			{"BeaconFreqAns", BeaconFreqAns, true, &BeaconFreqAnsPayload{BeaconFrequencyOK: true}, []byte{0x01}, nil},
+		}
+
+		for i, test := range testTable {
+			Convey(fmt.Sprintf("Testing: %s [%d]", test.Name, i), func() {
+				b, err := test.Payload.MarshalBinary()
+				if test.Error != nil {
+					So(err, ShouldResemble, test.Error)
+					return
+				}
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, test.Bytes)
+
+				var mac MACCommand
+				So(mac.UnmarshalBinary(test.Uplink, append([]byte{byte(test.CID)}, b...)), ShouldBeNil)
+				So(mac.Payload, ShouldResemble, test.Payload)
+			})
+		}
+
+		Convey("Then an out-of-band frequency is decoded and Validate returns an error", func() {
+			var pingSlotChannelReq PingSlotChannelReqPayload
+			So(pingSlotChannelReq.UnmarshalBinary([]byte{0xff, 0xff, 0xff, 3}), ShouldBeNil)
+			So(pingSlotChannelReq, ShouldResemble, PingSlotChannelReqPayload{Frequency: 16777215, DR: 3})
+			So(pingSlotChannelReq.Validate(8630000, 8700000), ShouldResemble, newError(ErrValueOutOfRange, "lorawan: Frequency must be 0 or between 8630000 and 8700000 (x 100 Hz)"))
+
+			var beaconFreqReq BeaconFreqReqPayload
+			So(beaconFreqReq.UnmarshalBinary([]byte{0xff, 0xff, 0xff}), ShouldBeNil)
+			So(beaconFreqReq.Validate(8630000, 8700000), ShouldResemble, newError(ErrValueOutOfRange, "lorawan: Frequency must be 0 or between 8630000 and 8700000 (x 100 Hz)"))
+		})
+
+		Convey("Then Validate accepts 0 and a frequency within the band", func() {
+			So(PingSlotChannelReqPayload{}.Validate(8630000, 8700000), ShouldBeNil)
+			So(PingSlotChannelReqPayload{Frequency: 8671000}.Validate(8630000, 8700000), ShouldBeNil)
+			So(BeaconFreqReqPayload{}.Validate(8630000, 8700000), ShouldBeNil)
+			So(BeaconFreqReqPayload{Frequency: 8695250}.Validate(8630000, 8700000), ShouldBeNil)
+		})
+	})
+}
+
+func TestMACCommandRFUBits(t *testing.T) {
+	Convey("Given a set of MAC commands with RFU bits set", t, func() {
+		testTable := []struct {
+			Name    string
+			CID     CID
+			Uplink  bool
+			Bytes   []byte
+			Payload MACCommandPayload
+		}{
+			{"LinkADRReq", LinkADRReq, false, []byte{0x52, 0x07, 0x00, 0x81}, &LinkADRReqPayload{DataRate: 5, TXPower: 2, ChMask: ChMask{true, true, true}, Redundancy: Redundancy{NbRep: 1, RFU: 0x80}}},
+			{"LinkADRAns", LinkADRAns, true, []byte{0x87}, &LinkADRAnsPayload{ChannelMaskACK: true, DataRateACK: true, PowerACK: true, RFU: 0x80}},
+			{"RXParamSetupAns", RXParamSetupAns, true, []byte{0x09}, &RX2SetupAnsPayload{ChannelACK: true, RFU: 0x08}},
+			{"DevStatusAns", DevStatusAns, true, []byte{0x64, 0xfb}, &DevStatusAnsPayload{Battery: 100, Margin: -5, RFU: 0xc0}},
+			{"NewChannelAns", NewChannelAns, true, []byte{0x43}, &NewChannelAnsPayload{ChannelFrequencyOK: true, DataRateRangeOK: true, RFU: 0x40}},
+			{"DutyCycleReq", DutyCycleReq, false, []byte{0x1f}, &DutyCycleReqPayload{MaxDCCycle: 15, RFU: 0x10}},
+			{"DutyCycleReq (MaxDCCycle=255)", DutyCycleReq, false, []byte{0xff}, &DutyCycleReqPayload{MaxDCCycle: 255}},
+			{"RXTimingSetupReq", RXTimingSetupReq, false, []byte{0x21}, &RXTimingSetupReqPayload{Delay: 1, RFU: 0x20}},
+			{"TXParamSetupReq", TXParamSetupReq, false, []byte{0x95}, &TXParamSetupReqPayload{UplinkDwellTime: DwellTime400ms, MaxEIRP: 16, RFU: 0x80}},
+			{"DLChannelAns", DLChannelAns, true, []byte{0x11}, &DLChannelAnsPayload{ChannelFrequencyOK: true, RFU: 0x10}},
+			{"ResetInd", ResetInd, true, []byte{0x31}, &ResetIndPayload{DevLoRaWANVersion: Version{Minor: 1, RFU: 0x30}}},
+			{"ForceRejoinReq", ForceRejoinReq, false, []byte{0xa3, 0xc1}, &ForceRejoinReqPayload{MaxRetries: 1, RejoinType: RejoinRequestType2, DR: 3, RFU: 0xc080}},
+			{"RejoinParamSetupAns", RejoinParamSetupAns, true, []byte{0x03}, &RejoinParamSetupAnsPayload{TimeOK: true, RFU: 0x02}},
+			{"PingSlotInfoReq", PingSlotInfoReq, true, []byte{0x45}, &PingSlotInfoReqPayload{Periodicity: 5, RFU: 0x40}},
+			{"PingSlotChannelReq", PingSlotChannelReq, false, []byte{24, 79, 132, 0x53}, &PingSlotChannelReqPayload{Frequency: 8671000, DR: 3, RFU: 0x50}},
+			{"PingSlotChannelAns", PingSlotChannelAns, true, []byte{0x82}, &PingSlotChannelAnsPayload{DataRateOK: true, RFU: 0x80}},
+			{"BeaconFreqAns", BeaconFreqAns, true, []byte{0xff}, &BeaconFreqAnsPayload{BeaconFrequencyOK: true, RFU: 0xfe}},
+		}
+
+		for _, test := range testTable {
+			Convey("Testing: "+test.Name, func() {
+				var mac MACCommand
+				So(mac.UnmarshalBinary(test.Uplink, append([]byte{byte(test.CID)}, test.Bytes...)), ShouldBeNil)
+				So(mac.Payload, ShouldResemble, test.Payload)
+
+				Convey("Then MarshalBinary returns the original bytes", func() {
+					b, err := mac.Payload.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(b, ShouldResemble, test.Bytes)
+				})
+			})
+		}
+	})
+}
@@ -31,99 +31,114 @@ func (p MACPayload) marshalPayload() ([]byte, error) {
 	return out, nil
 }
 
-func (p *MACPayload) unmarshalPayload(uplink bool, data []byte) error {
+func (p *MACPayload) unmarshalPayload(codec *Codec, uplink bool, data []byte) error {
 	if p.FPort == nil {
 		panic("lorawan: FPort must be set before calling unmarshalPayload, this is a bug!")
 	}
 
 	// payload contains MAC commands
 	if *p.FPort == 0 {
-		var pLen int
-		p.FRMPayload = make([]Payload, 0)
-		for i := 0; i < len(data); i++ {
-			if _, s, err := getMACPayloadAndSize(uplink, cid(data[i])); err != nil {
-				pLen = 0
-			} else {
-				pLen = s
-			}
-
-			// check if the remaining bytes are >= CID byte + payload size
-			if len(data[i:]) < pLen+1 {
-				return errors.New("lorawan: not enough remaining bytes")
-			}
-
-			mc := &MACCommand{}
-			if err := mc.UnmarshalBinary(uplink, data[i:i+1+pLen]); err != nil {
-				return err
-			}
-			p.FRMPayload = append(p.FRMPayload, mc)
-
-			// go to the next command (skip the payload bytes of the current command)
-			i = i + pLen
+		macs, err := codec.unmarshalMACCommands(uplink, data)
+		if err != nil {
+			return wrapDecodeError("FRMPayload", 0, err)
+		}
+		p.FRMPayload = make([]Payload, 0, len(macs))
+		for i := range macs {
+			p.FRMPayload = append(p.FRMPayload, &macs[i])
 		}
 
 	} else {
 		// payload contains user defined data
-		p.FRMPayload = []Payload{&DataPayload{}}
-		if err := p.FRMPayload[0].UnmarshalBinary(uplink, data); err != nil {
-			return err
-		}
+		p.FRMPayload = []Payload{codec.unmarshalDataPayload(data)}
 	}
 	return nil
 }
 
 // MarshalBinary marshals the object in binary form.
 func (p MACPayload) MarshalBinary() ([]byte, error) {
-	var b []byte
-	var out []byte
-	var err error
-
-	b, err = p.FHDR.MarshalBinary()
+	out, err := p.AppendBinary(nil)
 	if err != nil {
 		return nil, err
 	}
-	out = append(out, b...)
+	return out, nil
+}
+
+// AppendBinary appends the object in binary form to out. Unless the
+// FRMPayload or FOpts contain MAC command payloads, this does not allocate
+// when out has enough capacity.
+func (p MACPayload) AppendBinary(out []byte) ([]byte, error) {
+	start := len(out)
+
+	out, err := p.FHDR.AppendBinary(out)
+	if err != nil {
+		return out[:start], err
+	}
 
 	if p.FPort == nil {
 		if len(p.FRMPayload) != 0 {
-			return nil, errors.New("lorawan: FPort must be set when FRMPayload is not empty")
+			return out[:start], errors.New("lorawan: FPort must be set when FRMPayload is not empty")
 		}
 		return out, nil
-	} else if len(p.FHDR.FOpts) != 0 && *p.FPort == 0 {
-		return nil, errors.New("lorawan: FPort must not be 0 when FOpts are set")
+	} else if (len(p.FHDR.FOpts) != 0 || len(p.FHDR.RawFOpts) != 0) && *p.FPort == 0 {
+		return out[:start], errors.New("lorawan: FPort must not be 0 when FOpts are set")
 	}
 
 	out = append(out, *p.FPort)
 
-	if b, err = p.marshalPayload(); err != nil {
-		return nil, err
+	for _, fp := range p.FRMPayload {
+		if dp, ok := fp.(*DataPayload); ok {
+			out = append(out, dp.Bytes...)
+			continue
+		}
+
+		if mac, ok := fp.(*MACCommand); ok {
+			if *p.FPort != 0 {
+				return out[:start], errors.New("lorawan: a MAC command is only allowed when FPort=0")
+			}
+			out, err = mac.AppendBinary(out)
+		} else {
+			out, err = appendPayload(out, fp)
+		}
+		if err != nil {
+			return out[:start], err
+		}
 	}
-	out = append(out, b...)
+
 	return out, nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// UnmarshalBinary decodes the object from binary form. On error, a
+// *DecodeError is returned.
 func (p *MACPayload) UnmarshalBinary(uplink bool, data []byte) error {
+	if err := p.unmarshalBinary(DefaultCodec, uplink, false, data); err != nil {
+		return wrapDecodeError("MACPayload", 0, err)
+	}
+	return nil
+}
+
+// unmarshalBinary decodes the object from binary form. When rawFOpts is
+// set, the FOpts are not decoded (see FHDR.RawFOpts).
+func (p *MACPayload) unmarshalBinary(codec *Codec, uplink, rawFOpts bool, data []byte) error {
 	dataLen := len(data)
 
 	// check that there are enough bytes to decode a minimal FHDR
 	if dataLen < 7 {
-		return errors.New("lorawan: at least 7 bytes needed to decode FHDR")
+		return wrapDecodeError("FHDR", 0, newError(ErrTooShort, "lorawan: at least 7 bytes needed to decode FHDR"))
 	}
 
 	// unmarshal FCtrl so we know the FOptsLen
 	if err := p.FHDR.FCtrl.UnmarshalBinary(data[4:5]); err != nil {
-		return err
+		return wrapDecodeError("FHDR.FCtrl", 4, err)
 	}
 
 	// check that there are at least as many bytes as FOptsLen claims
 	if dataLen < 7+int(p.FHDR.FCtrl.fOptsLen) {
-		return errors.New("lorawan: not enough bytes to decode FHDR")
+		return wrapDecodeError("FHDR.FCtrl", 4, newError(ErrInvalidFOptsLen, "lorawan: not enough bytes to decode FHDR"))
 	}
 
 	// decode the full FHDR (including optional FOpts)
-	if err := p.FHDR.UnmarshalBinary(uplink, data[0:7+p.FHDR.FCtrl.fOptsLen]); err != nil {
-		return err
+	if err := p.FHDR.unmarshalBinary(codec, uplink, rawFOpts, data[0:7+p.FHDR.FCtrl.fOptsLen]); err != nil {
+		return wrapDecodeError("FHDR", 0, err)
 	}
 
 	// decode the optional FPort
@@ -135,11 +150,11 @@ func (p *MACPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	// decode the rest of the payload (if present)
 	if dataLen > 7+int(p.FHDR.FCtrl.fOptsLen)+1 {
 		if p.FPort != nil && *p.FPort == 0 && p.FHDR.FCtrl.fOptsLen > 0 {
-			return errors.New("lorawan: FPort must not be 0 when FOpts are set")
+			return wrapDecodeError("FPort", 7+int(p.FHDR.FCtrl.fOptsLen), ErrFPortZeroWithFOpts)
 		}
 
-		if err := p.unmarshalPayload(uplink, data[7+p.FHDR.FCtrl.fOptsLen+1:]); err != nil {
-			return err
+		if err := p.unmarshalPayload(codec, uplink, data[7+p.FHDR.FCtrl.fOptsLen+1:]); err != nil {
+			return wrapDecodeError("", 7+int(p.FHDR.FCtrl.fOptsLen)+1, err)
 		}
 	}
 
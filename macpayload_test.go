@@ -83,7 +83,7 @@ func TestMACPayload(t *testing.T) {
 			b := []byte{4, 3, 2, 1, 0, 0}
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := p.UnmarshalBinary(true, b)
-				So(err, ShouldResemble, errors.New("lorawan: at least 7 bytes needed to decode FHDR"))
+				So(err, ShouldResemble, &DecodeError{Path: "MACPayload.FHDR", Offset: 0, Err: newError(ErrTooShort, "lorawan: at least 7 bytes needed to decode FHDR")})
 			})
 		})
 
@@ -91,7 +91,7 @@ func TestMACPayload(t *testing.T) {
 			b := []byte{4, 3, 2, 1, 3, 0, 0, 0, 0}
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := p.UnmarshalBinary(true, b)
-				So(err, ShouldResemble, errors.New("lorawan: not enough bytes to decode FHDR"))
+				So(err, ShouldResemble, &DecodeError{Path: "MACPayload.FHDR.FCtrl", Offset: 4, Err: newError(ErrInvalidFOptsLen, "lorawan: not enough bytes to decode FHDR")})
 			})
 		})
 
@@ -107,7 +107,7 @@ func TestMACPayload(t *testing.T) {
 			b := []byte{4, 3, 2, 1, 0, 0, 0, 0, 6, 10}
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := p.UnmarshalBinary(true, b)
-				So(err, ShouldResemble, errors.New("lorawan: not enough remaining bytes"))
+				So(err, ShouldResemble, &DecodeError{Path: "MACPayload.FRMPayload[0]", Offset: 8, Err: newError(ErrTooShort, "lorawan: not enough remaining bytes")})
 			})
 		})
 
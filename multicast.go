@@ -0,0 +1,135 @@
+package lorawan
+
+import (
+	"errors"
+)
+
+// DeriveMcRootKey derives the McRootKey from the AppKey (LoRaWAN 1.1) or
+// GenAppKey (LoRaWAN 1.0):
+// LoRaWAN 1.0: aes128_encrypt(GenAppKey, 0x00 | pad16)
+// LoRaWAN 1.1: aes128_encrypt(AppKey, 0x20 | pad16)
+// See section '3.1 Multicast key derivation' of the LoRaWAN Remote
+// Multicast Setup specification for more details.
+func DeriveMcRootKey(appKey AES128Key, macVersion MACVersion) (AES128Key, error) {
+	var b [16]byte
+	if macVersion >= LoRaWAN1_1 {
+		b[0] = 0x20
+	}
+	return deriveKey(appKey, b)
+}
+
+// DeriveMcKEKey derives the McKEKey, used for encrypting the McKey:
+// aes128_encrypt(McRootKey, 0x00 | pad16)
+func DeriveMcKEKey(mcRootKey AES128Key) (AES128Key, error) {
+	return deriveKey(mcRootKey, [16]byte{})
+}
+
+// EncryptMcKey encrypts the McKey with the McKEKey, so that it can be sent
+// to the device in the McGroupSetupReq.
+// Note that the McKey is encrypted using the AES decrypt operation, so that
+// the device only needs to implement AES encrypt.
+func EncryptMcKey(mcKEKey AES128Key, mcKey AES128Key) (AES128Key, error) {
+	var out AES128Key
+	if err := (SoftwareKeyProvider{}).decryptBlocks(mcKEKey, out[:], mcKey[:]); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// DecryptMcKey decrypts the encrypted McKey (as sent in the
+// McGroupSetupReq) with the McKEKey:
+// aes128_encrypt(McKEKey, McKey_encrypted)
+func DecryptMcKey(mcKEKey AES128Key, mcKeyEncrypted AES128Key) (AES128Key, error) {
+	return deriveKey(mcKEKey, mcKeyEncrypted)
+}
+
+// DeriveMcAppSKey derives the McAppSKey of the multicast group:
+// aes128_encrypt(McKey, 0x01 | McAddr | pad16)
+func DeriveMcAppSKey(mcKey AES128Key, mcAddr DevAddr) (AES128Key, error) {
+	return deriveMcSessionKey(0x01, mcKey, mcAddr)
+}
+
+// DeriveMcNwkSKey derives the McNwkSKey (named McNetSKey in the
+// specification) of the multicast group:
+// aes128_encrypt(McKey, 0x02 | McAddr | pad16)
+func DeriveMcNwkSKey(mcKey AES128Key, mcAddr DevAddr) (AES128Key, error) {
+	return deriveMcSessionKey(0x02, mcKey, mcAddr)
+}
+
+func deriveMcSessionKey(typ byte, mcKey AES128Key, mcAddr DevAddr) (AES128Key, error) {
+	var b [16]byte
+	b[0] = typ
+
+	// little endian
+	for i, v := range mcAddr {
+		b[4-i] = v
+	}
+
+	return deriveKey(mcKey, b)
+}
+
+// MulticastSession represents a multicast group session. The FCnt is the
+// downlink frame-counter of the multicast group.
+type MulticastSession struct {
+	McAddr    DevAddr
+	McAppSKey AES128Key
+	McNwkSKey AES128Key
+	FCnt      uint32
+}
+
+// NewMulticastSession returns a MulticastSession for the given McAddr,
+// with the session keys derived from the McKey.
+func NewMulticastSession(mcKey AES128Key, mcAddr DevAddr, fCnt uint32) (MulticastSession, error) {
+	s := MulticastSession{
+		McAddr: mcAddr,
+		FCnt:   fCnt,
+	}
+
+	var err error
+	if s.McAppSKey, err = DeriveMcAppSKey(mcKey, mcAddr); err != nil {
+		return s, err
+	}
+	if s.McNwkSKey, err = DeriveMcNwkSKey(mcKey, mcAddr); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// NewDownlink returns an unconfirmed data down PHYPayload for the
+// multicast group. The FRMPayload is encrypted with the McAppSKey and the
+// MIC is set using the McNwkSKey. On success, the FCnt is incremented.
+// Note that multicast frames can not contain MAC commands, thus fPort must
+// be > 0.
+func (s *MulticastSession) NewDownlink(fPort uint8, data []byte) (PHYPayload, error) {
+	if fPort == 0 {
+		return PHYPayload{}, errors.New("lorawan: FPort must be > 0 for multicast downlinks")
+	}
+
+	b := make([]byte, len(data))
+	copy(b, data)
+
+	phy := PHYPayload{
+		MHDR: MHDR{
+			MType: UnconfirmedDataDown,
+			Major: LoRaWANR1,
+		},
+		MACPayload: &MACPayload{
+			FHDR: FHDR{
+				DevAddr: s.McAddr,
+				FCnt:    s.FCnt,
+			},
+			FPort:      &fPort,
+			FRMPayload: []Payload{&DataPayload{Bytes: b}},
+		},
+	}
+
+	if err := phy.EncryptFRMPayload(s.McAppSKey); err != nil {
+		return PHYPayload{}, err
+	}
+	if err := phy.SetMIC(s.McNwkSKey); err != nil {
+		return PHYPayload{}, err
+	}
+
+	s.FCnt++
+	return phy, nil
+}
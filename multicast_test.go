@@ -0,0 +1,103 @@
+package lorawan
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMulticastKeys(t *testing.T) {
+	Convey("Given AppKey=000102030405060708090a0b0c0d0e0f", t, func() {
+		appKey := AES128Key{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+		Convey("Then DeriveMcRootKey for LoRaWAN 1.0 returns c6a13b37878f5b826f4f8162a1c8d879", func() {
+			key, err := DeriveMcRootKey(appKey, LoRaWAN1_0)
+			So(err, ShouldBeNil)
+			So(key.String(), ShouldEqual, "c6a13b37878f5b826f4f8162a1c8d879")
+		})
+
+		Convey("Then DeriveMcRootKey for LoRaWAN 1.1 returns 430bff9b049f19279455bd564133c73b", func() {
+			mcRootKey, err := DeriveMcRootKey(appKey, LoRaWAN1_1)
+			So(err, ShouldBeNil)
+			So(mcRootKey.String(), ShouldEqual, "430bff9b049f19279455bd564133c73b")
+
+			Convey("Then DeriveMcKEKey returns 0fc43a2a45fdb753dd065270b50ab9f2", func() {
+				mcKEKey, err := DeriveMcKEKey(mcRootKey)
+				So(err, ShouldBeNil)
+				So(mcKEKey.String(), ShouldEqual, "0fc43a2a45fdb753dd065270b50ab9f2")
+
+				Convey("Then EncryptMcKey returns 67608274fdd6c3937da6c58030273c60 for McKey=0102030405060708090a0b0c0d0e0f10", func() {
+					mcKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+					mcKeyEncrypted, err := EncryptMcKey(mcKEKey, mcKey)
+					So(err, ShouldBeNil)
+					So(mcKeyEncrypted.String(), ShouldEqual, "67608274fdd6c3937da6c58030273c60")
+
+					Convey("Then DecryptMcKey returns the McKey", func() {
+						key, err := DecryptMcKey(mcKEKey, mcKeyEncrypted)
+						So(err, ShouldBeNil)
+						So(key, ShouldEqual, mcKey)
+					})
+				})
+			})
+		})
+	})
+
+	Convey("Given McKey=0102030405060708090a0b0c0d0e0f10 and McAddr=01020304", t, func() {
+		mcKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		mcAddr := DevAddr{1, 2, 3, 4}
+
+		Convey("Then DeriveMcAppSKey returns 95cb4518ee375606735bbacbdce837fa", func() {
+			key, err := DeriveMcAppSKey(mcKey, mcAddr)
+			So(err, ShouldBeNil)
+			So(key.String(), ShouldEqual, "95cb4518ee375606735bbacbdce837fa")
+		})
+
+		Convey("Then DeriveMcNwkSKey returns c3f6b388bad6c000b23291ad52c11c7b", func() {
+			key, err := DeriveMcNwkSKey(mcKey, mcAddr)
+			So(err, ShouldBeNil)
+			So(key.String(), ShouldEqual, "c3f6b388bad6c000b23291ad52c11c7b")
+		})
+	})
+}
+
+func TestMulticastSession(t *testing.T) {
+	Convey("Given a MulticastSession with McKey=0102030405060708090a0b0c0d0e0f10, McAddr=01020304 and FCnt=5", t, func() {
+		mcKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		s, err := NewMulticastSession(mcKey, DevAddr{1, 2, 3, 4}, 5)
+		So(err, ShouldBeNil)
+		So(s.McAppSKey.String(), ShouldEqual, "95cb4518ee375606735bbacbdce837fa")
+		So(s.McNwkSKey.String(), ShouldEqual, "c3f6b388bad6c000b23291ad52c11c7b")
+
+		Convey("Then NewDownlink with FPort=0 returns an error", func() {
+			_, err := s.NewDownlink(0, []byte{1, 2, 3, 4})
+			So(err, ShouldResemble, errors.New("lorawan: FPort must be > 0 for multicast downlinks"))
+			So(s.FCnt, ShouldEqual, 5)
+		})
+
+		Convey("Then NewDownlink with FPort=10 and data=01020304 returns 60040302010005000a0f5bd8da29758714", func() {
+			data := []byte{1, 2, 3, 4}
+			phy, err := s.NewDownlink(10, data)
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, []byte{1, 2, 3, 4})
+			So(s.FCnt, ShouldEqual, 6)
+
+			b, err := phy.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(hex.EncodeToString(b), ShouldEqual, "60040302010005000a0f5bd8da29758714")
+
+			Convey("Then the MIC is valid and the FRMPayload can be decrypted", func() {
+				ok, err := phy.ValidateMIC(s.McNwkSKey)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+
+				So(phy.DecryptFRMPayload(s.McAppSKey), ShouldBeNil)
+				macPL, ok := phy.MACPayload.(*MACPayload)
+				So(ok, ShouldBeTrue)
+				So(macPL.FHDR.FCnt, ShouldEqual, 5)
+				So(macPL.FRMPayload, ShouldResemble, []Payload{&DataPayload{Bytes: []byte{1, 2, 3, 4}}})
+			})
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package lorawan
 
 import (
+	"encoding"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -35,18 +36,22 @@ func (e EUI64) String() string {
 
 // MarshalBinary implements encoding.BinaryMarshaler.
 func (e EUI64) MarshalBinary() ([]byte, error) {
-	out := make([]byte, len(e))
+	return e.appendBinary(make([]byte, 0, len(e))), nil
+}
+
+// appendBinary appends the object in binary form to b.
+func (e EUI64) appendBinary(b []byte) []byte {
 	// little endian
-	for i, v := range e {
-		out[len(e)-i-1] = v
+	for i := len(e) - 1; i >= 0; i-- {
+		b = append(b, e[i])
 	}
-	return out, nil
+	return b
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
 func (e *EUI64) UnmarshalBinary(data []byte) error {
 	if len(data) != len(e) {
-		return fmt.Errorf("lorawan: %d bytes of data are expected", len(e))
+		return newError(ErrInvalidLength, "lorawan: %d bytes of data are expected", len(e))
 	}
 	for i, v := range data {
 		// little endian
@@ -77,6 +82,25 @@ type Payload interface {
 	UnmarshalBinary(uplink bool, data []byte) error
 }
 
+// binaryAppender is implemented by payloads that can append their binary
+// form to an existing buffer.
+type binaryAppender interface {
+	AppendBinary(b []byte) ([]byte, error)
+}
+
+// appendPayload appends the given payload in binary form to b. When the
+// payload does not implement AppendBinary, MarshalBinary is used.
+func appendPayload(b []byte, p encoding.BinaryMarshaler) ([]byte, error) {
+	if a, ok := p.(binaryAppender); ok {
+		return a.AppendBinary(b)
+	}
+	pl, err := p.MarshalBinary()
+	if err != nil {
+		return b, err
+	}
+	return append(b, pl...), nil
+}
+
 // DataPayload represents a slice of bytes.
 type DataPayload struct {
 	Bytes []byte
@@ -87,6 +111,11 @@ func (p DataPayload) MarshalBinary() ([]byte, error) {
 	return p.Bytes, nil
 }
 
+// AppendBinary appends the object in binary form to b.
+func (p DataPayload) AppendBinary(b []byte) ([]byte, error) {
+	return append(b, p.Bytes...), nil
+}
+
 // UnmarshalBinary decodes the object from binary form.
 func (p *DataPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	p.Bytes = make([]byte, len(data))
@@ -103,26 +132,21 @@ type JoinRequestPayload struct {
 
 // MarshalBinary marshals the object in binary form.
 func (p JoinRequestPayload) MarshalBinary() ([]byte, error) {
-	out := make([]byte, 0, 18)
-	b, err := p.AppEUI.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
-	out = append(out, b...)
-	b, err = p.DevEUI.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
-	out = append(out, b...)
+	return p.AppendBinary(make([]byte, 0, 18))
+}
+
+// AppendBinary appends the object in binary form to b.
+func (p JoinRequestPayload) AppendBinary(b []byte) ([]byte, error) {
+	b = p.AppEUI.appendBinary(b)
+	b = p.DevEUI.appendBinary(b)
 	// little endian
-	out = append(out, p.DevNonce[1], p.DevNonce[0])
-	return out, nil
+	return append(b, p.DevNonce[1], p.DevNonce[0]), nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (p *JoinRequestPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	if len(data) != 18 {
-		return errors.New("lorawan: 18 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 18 bytes of data are expected")
 	}
 	if err := p.AppEUI.UnmarshalBinary(data[0:8]); err != nil {
 		return err
@@ -136,34 +160,48 @@ func (p *JoinRequestPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	return nil
 }
 
+// CFListType defines the CFListType, the last byte of the CFList (RFU for
+// LoRaWAN 1.0.0 and 1.0.1).
+type CFListType uint8
+
+// Supported CFList types
+const (
+	CFListChannel CFListType = 0
+	CFListChMask  CFListType = 1
+)
+
 // CFList represents a list of channel frequencies. Each frequency is in Hz
 // and must be multiple of 100, (since the frequency will be divided by 100
-// on encoding), the max allowed value is 2^24-1 * 100.
+// on encoding), the max allowed value is 2^24-1 * 100. The CFListType
+// (the 16th byte) is stored in JoinAcceptPayload.CFListType.
 type CFList [5]uint32
 
-// MarshalBinary marshals the object in binary form.
+// MarshalBinary marshals the object in binary form, with CFListType 0.
 func (l CFList) MarshalBinary() ([]byte, error) {
-	out := make([]byte, 0, 16)
+	return l.appendBinary(make([]byte, 0, 16), CFListChannel)
+}
+
+// appendBinary appends the object in binary form to out.
+func (l CFList) appendBinary(out []byte, t CFListType) ([]byte, error) {
+	start := len(out)
 	for _, f := range l {
 		if f%100 != 0 {
-			return nil, errors.New("lorawan: frequency must be a multiple of 100")
+			return out[:start], errors.New("lorawan: frequency must be a multiple of 100")
 		}
 		f = f / 100
 		if f > 16777215 { // 2^24 - 1
-			return nil, errors.New("lorawan: max value of frequency is 2^24-1")
+			return out[:start], errors.New("lorawan: max value of frequency is 2^24-1")
 		}
-		b := make([]byte, 4, 4)
-		binary.LittleEndian.PutUint32(b, f)
-		out = append(out, b[:3]...)
+		// little endian
+		out = append(out, byte(f), byte(f>>8), byte(f>>16))
 	}
-	// last byte is 0 / RFU
-	return append(out, 0), nil
+	return append(out, byte(t)), nil
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (l *CFList) UnmarshalBinary(data []byte) error {
 	if len(data) != 16 {
-		return errors.New("lorawan: 16 bytes of data are expected")
+		return newError(ErrInvalidLength, "lorawan: 16 bytes of data are expected")
 	}
 	for i := 0; i < 5; i++ {
 		l[i] = binary.LittleEndian.Uint32([]byte{
@@ -177,7 +215,18 @@ func (l *CFList) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// JoinType defines the JoinReqType: the type of the request that is
+// answered by a LoRaWAN 1.1 join-accept. For rejoin-requests, the JoinType
+// equals the RejoinType (e.g. JoinType(RejoinRequestType1)).
+type JoinType uint8
+
+// Supported join types
+const (
+	JoinRequestType JoinType = 0xff
+)
+
 // JoinAcceptPayload represents the join-accept message payload.
+// In LoRaWAN 1.1, the AppNonce is called JoinNonce.
 type JoinAcceptPayload struct {
 	AppNonce   [3]byte
 	NetID      [3]byte
@@ -185,11 +234,21 @@ type JoinAcceptPayload struct {
 	DLSettings DLsettings
 	RXDelay    uint8
 	CFList     *CFList
+	CFListType CFListType // only used when CFList is set
 }
 
 // MarshalBinary marshals the object in binary form.
 func (p JoinAcceptPayload) MarshalBinary() ([]byte, error) {
-	out := make([]byte, 0, 12)
+	out, err := p.AppendBinary(make([]byte, 0, 28))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AppendBinary appends the object in binary form to out.
+func (p JoinAcceptPayload) AppendBinary(out []byte) ([]byte, error) {
+	start := len(out)
 
 	// little endian
 	for i := len(p.AppNonce) - 1; i >= 0; i-- {
@@ -198,26 +257,19 @@ func (p JoinAcceptPayload) MarshalBinary() ([]byte, error) {
 	for i := len(p.NetID) - 1; i >= 0; i-- {
 		out = append(out, p.NetID[i])
 	}
+	out = append(out, p.DevAddr[3], p.DevAddr[2], p.DevAddr[1], p.DevAddr[0])
 
-	b, err := p.DevAddr.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
-	out = append(out, b...)
-
-	b, err = p.DLSettings.MarshalBinary()
+	out, err := p.DLSettings.appendBinary(out)
 	if err != nil {
-		return nil, err
+		return out[:start], err
 	}
-	out = append(out, b...)
 	out = append(out, byte(p.RXDelay))
 
 	if p.CFList != nil {
-		b, err = p.CFList.MarshalBinary()
+		out, err = p.CFList.appendBinary(out, p.CFListType)
 		if err != nil {
-			return nil, err
+			return out[:start], err
 		}
-		out = append(out, b...)
 	}
 
 	return out, nil
@@ -227,7 +279,7 @@ func (p JoinAcceptPayload) MarshalBinary() ([]byte, error) {
 func (p *JoinAcceptPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	l := len(data)
 	if l != 12 && l != 28 {
-		return errors.New("lorawan: 12 or 28 bytes of data are expected (28 bytes if CFList is present)")
+		return newError(ErrInvalidLength, "lorawan: 12 or 28 bytes of data are expected (28 bytes if CFList is present)")
 	}
 
 	// little endian
@@ -244,6 +296,8 @@ func (p *JoinAcceptPayload) UnmarshalBinary(uplink bool, data []byte) error {
 	if err := p.DLSettings.UnmarshalBinary(data[10:11]); err != nil {
 		return err
 	}
+	// bit 7 of the DLSettings is the OptNeg bit in the join-accept
+	p.DLSettings.OptNeg, p.DLSettings.RFU = p.DLSettings.RFU&0x80 != 0, 0
 	p.RXDelay = uint8(data[11])
 
 	if l == 28 {
@@ -251,7 +305,136 @@ func (p *JoinAcceptPayload) UnmarshalBinary(uplink bool, data []byte) error {
 		if err := p.CFList.UnmarshalBinary(data[12:]); err != nil {
 			return err
 		}
+		p.CFListType = CFListType(data[27])
+	}
+
+	return nil
+}
+
+// RejoinType defines the rejoin-request type.
+type RejoinType uint8
+
+// Supported rejoin-request types
+const (
+	RejoinRequestType0 RejoinType = 0
+	RejoinRequestType1 RejoinType = 1
+	RejoinRequestType2 RejoinType = 2
+)
+
+// RejoinRequestType02Payload represents the rejoin-request payload of
+// type 0 and 2 (LoRaWAN 1.1).
+//
+// Type 0 / 2 and type 1 are separate payload types as they have different
+// fields (NetID and RJcount0 vs JoinEUI and RJcount1), a different size and
+// their MIC is calculated with a different key (SNwkSIntKey vs JSIntKey).
+// A single type would contain fields that are not valid for the RejoinType.
+// The decoder selects the type based on the RejoinType byte.
+type RejoinRequestType02Payload struct {
+	RejoinType RejoinType
+	NetID      NetID
+	DevEUI     EUI64
+	RJCount0   uint16
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RejoinRequestType02Payload) MarshalBinary() ([]byte, error) {
+	out, err := p.AppendBinary(make([]byte, 0, 14))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AppendBinary appends the object in binary form to out.
+func (p RejoinRequestType02Payload) AppendBinary(out []byte) ([]byte, error) {
+	if p.RejoinType != RejoinRequestType0 && p.RejoinType != RejoinRequestType2 {
+		return out, errors.New("lorawan: RejoinType must be 0 or 2")
+	}
+
+	out = append(out, byte(p.RejoinType))
+
+	// little endian
+	for i := len(p.NetID) - 1; i >= 0; i-- {
+		out = append(out, p.NetID[i])
+	}
+	out = p.DevEUI.appendBinary(out)
+
+	return append(out, byte(p.RJCount0), byte(p.RJCount0>>8)), nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RejoinRequestType02Payload) UnmarshalBinary(uplink bool, data []byte) error {
+	if len(data) != 14 {
+		return newError(ErrInvalidLength, "lorawan: 14 bytes of data are expected")
+	}
+
+	p.RejoinType = RejoinType(data[0])
+	if p.RejoinType != RejoinRequestType0 && p.RejoinType != RejoinRequestType2 {
+		return newError(ErrValueOutOfRange, "lorawan: RejoinType must be 0 or 2")
+	}
+
+	// little endian
+	for i, v := range data[1:4] {
+		p.NetID[2-i] = v
+	}
+
+	if err := p.DevEUI.UnmarshalBinary(data[4:12]); err != nil {
+		return err
+	}
+	p.RJCount0 = binary.LittleEndian.Uint16(data[12:14])
+
+	return nil
+}
+
+// RejoinRequestType1Payload represents the rejoin-request payload of type 1
+// (LoRaWAN 1.1). See RejoinRequestType02Payload for type 0 and 2.
+type RejoinRequestType1Payload struct {
+	RejoinType RejoinType
+	JoinEUI    EUI64
+	DevEUI     EUI64
+	RJCount1   uint16
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p RejoinRequestType1Payload) MarshalBinary() ([]byte, error) {
+	out, err := p.AppendBinary(make([]byte, 0, 19))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AppendBinary appends the object in binary form to out.
+func (p RejoinRequestType1Payload) AppendBinary(out []byte) ([]byte, error) {
+	if p.RejoinType != RejoinRequestType1 {
+		return out, errors.New("lorawan: RejoinType must be 1")
+	}
+
+	out = append(out, byte(p.RejoinType))
+	out = p.JoinEUI.appendBinary(out)
+	out = p.DevEUI.appendBinary(out)
+
+	return append(out, byte(p.RJCount1), byte(p.RJCount1>>8)), nil
+}
+
+// UnmarshalBinary decodes the object from binary form.
+func (p *RejoinRequestType1Payload) UnmarshalBinary(uplink bool, data []byte) error {
+	if len(data) != 19 {
+		return newError(ErrInvalidLength, "lorawan: 19 bytes of data are expected")
+	}
+
+	p.RejoinType = RejoinType(data[0])
+	if p.RejoinType != RejoinRequestType1 {
+		return newError(ErrValueOutOfRange, "lorawan: RejoinType must be 1")
+	}
+
+	if err := p.JoinEUI.UnmarshalBinary(data[1:9]); err != nil {
+		return err
+	}
+	if err := p.DevEUI.UnmarshalBinary(data[9:17]); err != nil {
+		return err
 	}
+	p.RJCount1 = binary.LittleEndian.Uint16(data[17:19])
 
 	return nil
 }
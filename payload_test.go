@@ -95,7 +95,7 @@ func TestJoinRequestPayload(t *testing.T) {
 			b := make([]byte, 17)
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := p.UnmarshalBinary(false, b)
-				So(err, ShouldResemble, errors.New("lorawan: 18 bytes of data are expected"))
+				So(err, ShouldResemble, newError(ErrInvalidLength, "lorawan: 18 bytes of data are expected"))
 			})
 		})
 
@@ -190,7 +190,7 @@ func TestJoinAcceptPayload(t *testing.T) {
 			b := make([]byte, 11)
 			Convey("Then UnmarshalBinary returns an error", func() {
 				err := p.UnmarshalBinary(false, b)
-				So(err, ShouldResemble, errors.New("lorawan: 12 or 28 bytes of data are expected (28 bytes if CFList is present)"))
+				So(err, ShouldResemble, newError(ErrInvalidLength, "lorawan: 12 or 28 bytes of data are expected (28 bytes if CFList is present)"))
 			})
 		})
 
@@ -227,7 +227,91 @@ func TestJoinAcceptPayload(t *testing.T) {
 					867700000,
 					867900000,
 				})
+				So(p.CFListType, ShouldEqual, CFListChannel)
 			})
 		})
+
+		Convey("Given a join-accept with OptNeg set and a CFList of CFListType 1", func() {
+			b := []byte{1, 1, 1, 2, 2, 2, 4, 3, 2, 1, 0xe7, 9, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+			Convey("Then UnmarshalBinary decodes OptNeg and the CFListType", func() {
+				So(p.UnmarshalBinary(false, b), ShouldBeNil)
+				So(p.DLSettings, ShouldResemble, DLsettings{OptNeg: true, RX2DataRate: 7, RX1DRoffset: 6})
+				So(p.CFListType, ShouldEqual, CFListChMask)
+
+				Convey("Then MarshalBinary returns the original bytes", func() {
+					out, err := p.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(out, ShouldResemble, b)
+				})
+			})
+		})
+	})
+}
+
+func TestRejoinRequestType02Payload(t *testing.T) {
+	Convey("Given a RejoinRequestType02Payload with RejoinType=2, NetID=010203, DevEUI=0102030405060708 and RJCount0=258", t, func() {
+		p := RejoinRequestType02Payload{
+			RejoinType: RejoinRequestType2,
+			NetID:      NetID{1, 2, 3},
+			DevEUI:     EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+			RJCount0:   258,
+		}
+
+		Convey("Then MarshalBinary returns []byte{2, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1, 2, 1}", func() {
+			b, err := p.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{2, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1, 2, 1})
+
+			Convey("Then UnmarshalBinary returns the same payload", func() {
+				var out RejoinRequestType02Payload
+				So(out.UnmarshalBinary(true, b), ShouldBeNil)
+				So(out, ShouldResemble, p)
+			})
+		})
+
+		Convey("Given RejoinType=1", func() {
+			p.RejoinType = RejoinRequestType1
+			Convey("Then MarshalBinary returns an error", func() {
+				_, err := p.MarshalBinary()
+				So(err, ShouldResemble, errors.New("lorawan: RejoinType must be 0 or 2"))
+			})
+		})
+	})
+
+	Convey("Given an empty RejoinRequestType02Payload", t, func() {
+		var p RejoinRequestType02Payload
+		Convey("Then UnmarshalBinary with 13 bytes returns an error", func() {
+			So(p.UnmarshalBinary(true, make([]byte, 13)), ShouldResemble, newError(ErrInvalidLength, "lorawan: 14 bytes of data are expected"))
+		})
+	})
+}
+
+func TestRejoinRequestType1Payload(t *testing.T) {
+	Convey("Given a RejoinRequestType1Payload with JoinEUI=0102030405060708, DevEUI=0203040506070809 and RJCount1=3", t, func() {
+		p := RejoinRequestType1Payload{
+			RejoinType: RejoinRequestType1,
+			JoinEUI:    EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+			DevEUI:     EUI64{2, 3, 4, 5, 6, 7, 8, 9},
+			RJCount1:   3,
+		}
+
+		Convey("Then MarshalBinary returns []byte{1, 8, 7, 6, 5, 4, 3, 2, 1, 9, 8, 7, 6, 5, 4, 3, 2, 3, 0}", func() {
+			b, err := p.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{1, 8, 7, 6, 5, 4, 3, 2, 1, 9, 8, 7, 6, 5, 4, 3, 2, 3, 0})
+
+			Convey("Then UnmarshalBinary returns the same payload", func() {
+				var out RejoinRequestType1Payload
+				So(out.UnmarshalBinary(true, b), ShouldBeNil)
+				So(out, ShouldResemble, p)
+			})
+		})
+	})
+
+	Convey("Given an empty RejoinRequestType1Payload", t, func() {
+		var p RejoinRequestType1Payload
+		Convey("Then UnmarshalBinary with RejoinType=0 returns an error", func() {
+			So(p.UnmarshalBinary(true, make([]byte, 19)), ShouldResemble, newError(ErrValueOutOfRange, "lorawan: RejoinType must be 1"))
+		})
 	})
 }
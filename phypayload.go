@@ -3,14 +3,11 @@
 package lorawan
 
 import (
-	"crypto/aes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
-
-	"github.com/jacobsa/crypto/cmac"
 )
 
 // MType represents the message type.
@@ -27,15 +24,149 @@ const (
 	UnconfirmedDataDown
 	ConfirmedDataUp
 	ConfirmedDataDown
-	RFU
+	RejoinRequest
 	Proprietary
 )
 
+// RFU is the former name of the RejoinRequest MType, which was RFU before
+// LoRaWAN 1.1.
+//
+// Deprecated: use RejoinRequest.
+const RFU = RejoinRequest
+
 // Supported major versions
 const (
 	LoRaWANR1 Major = 0
 )
 
+// MACVersion defines the LoRaWAN MAC version.
+type MACVersion byte
+
+// Supported MAC versions. The versions are ordered, e.g. the MAC commands
+// that were introduced by LoRaWAN 1.0.3 are defined for all
+// versions >= LoRaWAN1_0_3.
+const (
+	LoRaWAN1_0_0 MACVersion = iota
+	LoRaWAN1_0_1
+	LoRaWAN1_0_2
+	LoRaWAN1_0_3
+	LoRaWAN1_0_4
+	LoRaWAN1_1
+)
+
+// LoRaWAN1_0 is used for calculating the LoRaWAN 1.0.x MIC, which is the
+// same for all 1.0.x versions.
+const LoRaWAN1_0 = LoRaWAN1_0_0
+
+// String implements fmt.Stringer.
+func (v MACVersion) String() string {
+	switch v {
+	case LoRaWAN1_0_0, LoRaWAN1_0_1, LoRaWAN1_0_2, LoRaWAN1_0_3, LoRaWAN1_0_4:
+		return fmt.Sprintf("1.0.%d", v-LoRaWAN1_0_0)
+	case LoRaWAN1_1:
+		return "1.1"
+	default:
+		return fmt.Sprintf("MACVersion(%d)", v)
+	}
+}
+
+// MICOption is an option for SetMIC and ValidateMIC.
+type MICOption func(*micOptions)
+
+type micOptions struct {
+	macVersion  MACVersion
+	sNwkSIntKey KeyHandle
+	confFCnt    uint32
+	txDR        uint8
+	txCh        uint8
+	joinReqType *JoinType
+	joinEUI     EUI64
+	devNonce    [2]byte
+}
+
+// WithMACVersion sets the MAC version used for calculating the MIC. When not
+// set, the MAC version of the codec which decoded the PHYPayload is used
+// (see WithCodecMACVersion), else the LoRaWAN 1.0 MIC is calculated.
+func WithMACVersion(macVersion MACVersion) MICOption {
+	return func(o *micOptions) {
+		o.macVersion = macVersion
+	}
+}
+
+// WithSNwkSIntKey sets the SNwkSIntKey for calculating the LoRaWAN 1.1
+// uplink MIC. In this case the key passed to SetMIC or ValidateMIC must be
+// the FNwkSIntKey.
+func WithSNwkSIntKey(key AES128Key) MICOption {
+	return WithSNwkSIntKeyHandle(key)
+}
+
+// WithSNwkSIntKeyHandle sets the handle of the SNwkSIntKey for calculating
+// the LoRaWAN 1.1 uplink MIC with SetMICWithProvider or
+// ValidateMICWithProvider. For SetMICWithContext and ValidateMICWithContext,
+// the handle should be the *CipherContext of the SNwkSIntKey.
+func WithSNwkSIntKeyHandle(key KeyHandle) MICOption {
+	return func(o *micOptions) {
+		o.sNwkSIntKey = key
+	}
+}
+
+// WithConfFCnt sets the frame-counter of the confirmed frame that is
+// acknowledged by the ACK bit (LoRaWAN 1.1 only). It is ignored when the
+// ACK bit is not set.
+func WithConfFCnt(fCnt uint32) MICOption {
+	return func(o *micOptions) {
+		o.confFCnt = fCnt
+	}
+}
+
+// WithTxDR sets the data-rate of the uplink transmission (LoRaWAN 1.1 only).
+func WithTxDR(dr uint8) MICOption {
+	return func(o *micOptions) {
+		o.txDR = dr
+	}
+}
+
+// WithTxCh sets the channel index of the uplink transmission (LoRaWAN 1.1
+// only).
+func WithTxCh(ch uint8) MICOption {
+	return func(o *micOptions) {
+		o.txCh = ch
+	}
+}
+
+// WithJoinReqType sets the JoinReqType, JoinEUI and DevNonce of the request
+// that is answered by a LoRaWAN 1.1 join-accept (OptNeg is set). These are
+// part of the LoRaWAN 1.1 join-accept MIC, which is only used when the MAC
+// version is LoRaWAN 1.1 (see WithMACVersion). In this case the key passed
+// to SetMIC or ValidateMIC must be the JSIntKey.
+func WithJoinReqType(joinReqType JoinType, joinEUI EUI64, devNonce [2]byte) MICOption {
+	return func(o *micOptions) {
+		o.joinReqType = &joinReqType
+		o.joinEUI = joinEUI
+		o.devNonce = devNonce
+	}
+}
+
+// getMICOptions returns the MIC options. When the PHYPayload was decoded by
+// a codec with a MAC version, this is used as the default MAC version.
+func (p *PHYPayload) getMICOptions(opts []MICOption) micOptions {
+	var o micOptions
+	p.setMICOptions(&o, opts)
+	return o
+}
+
+// setMICOptions resets o and applies the given options (see
+// getMICOptions).
+func (p *PHYPayload) setMICOptions(o *micOptions, opts []MICOption) {
+	*o = micOptions{}
+	if c := p.getCodec(); c.macVersion != nil {
+		o.macVersion = *c.macVersion
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
 // AES128Key represents a 128 bit AES key.
 type AES128Key [16]byte
 
@@ -79,19 +210,26 @@ func (k *AES128Key) Scan(src interface{}) error {
 type MHDR struct {
 	MType MType
 	Major Major
+	RFU   uint8 // RFU bits (bits 2-4) as received, preserved when re-marshaling
 }
 
 // MarshalBinary marshals the object in binary form.
 func (h MHDR) MarshalBinary() ([]byte, error) {
-	return []byte{byte(h.Major) ^ (byte(h.MType) << 5)}, nil
+	return h.appendBinary(make([]byte, 0, 1)), nil
+}
+
+// appendBinary appends the object in binary form to b.
+func (h MHDR) appendBinary(b []byte) []byte {
+	return append(b, byte(h.Major)&3^h.RFU&0x1c^(byte(h.MType)<<5))
 }
 
 // UnmarshalBinary decodes the object from binary form.
 func (h *MHDR) UnmarshalBinary(data []byte) error {
 	if len(data) != 1 {
-		return errors.New("lorawan: 1 byte of data is expected")
+		return newError(ErrInvalidLength, "lorawan: 1 byte of data is expected")
 	}
 	h.Major = Major(data[0] & 3)
+	h.RFU = data[0] & 0x1c
 	h.MType = MType((data[0] & 224) >> 5)
 	return nil
 }
@@ -102,10 +240,58 @@ type PHYPayload struct {
 	MHDR       MHDR
 	MACPayload Payload
 	MIC        [4]byte
+
+	// raw holds the bytes from which the PHYPayload was decoded (or
+	// decrypted in case of a join-accept). When set, the MIC is validated
+	// over these bytes instead of the re-marshaled fields.
+	raw []byte
+
+	// codec holds the Codec used for decoding the PHYPayload. It is used
+	// for decoding the decrypted MAC commands.
+	codec *Codec
+}
+
+// getCodec returns the codec used for decoding the PHYPayload, or the
+// DefaultCodec.
+func (p *PHYPayload) getCodec() *Codec {
+	if p.codec == nil {
+		return DefaultCodec
+	}
+	return p.codec
+}
+
+// appendMICPayload appends MHDR | MACPayload in binary form to b, as used
+// for the MIC calculation. When the PHYPayload was decoded from binary form,
+// the original bytes are appended.
+func (p PHYPayload) appendMICPayload(b []byte) ([]byte, error) {
+	if len(p.raw) > 4 {
+		return append(b, p.raw[:len(p.raw)-4]...), nil
+	}
+
+	return appendPayload(p.MHDR.appendBinary(b), p.MACPayload)
+}
+
+// Remarshal re-marshals the PHYPayload from its fields and uses the result
+// as its binary form for the MIC calculation. Use this after modifying a
+// decoded PHYPayload, as the MIC is otherwise validated over the received
+// bytes.
+func (p *PHYPayload) Remarshal() error {
+	p.raw = nil
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	p.raw = b
+	return nil
 }
 
 // calculateMIC calculates and returns the MIC.
-func (p PHYPayload) calculateMIC(key AES128Key) ([]byte, error) {
+// For LoRaWAN 1.0 and LoRaWAN 1.1 downlink frames, key is the NwkSKey or
+// SNwkSIntKey. For LoRaWAN 1.1 uplink frames, key is the FNwkSIntKey and the
+// SNwkSIntKey is taken from the options.
+// See section '4.4 Message Integrity Code (MIC)' of the LoRaWAN 1.1
+// specification for more details.
+func (p PHYPayload) calculateMIC(provider KeyProvider, key KeyHandle, o micOptions) ([]byte, error) {
 	if p.MACPayload == nil {
 		return []byte{}, errors.New("lorawan: MACPayload should not be empty")
 	}
@@ -115,48 +301,77 @@ func (p PHYPayload) calculateMIC(key AES128Key) ([]byte, error) {
 		return []byte{}, errors.New("lorawan: MACPayload should be of type *MACPayload")
 	}
 
-	var b []byte
-	var err error
-	var micBytes []byte
-
-	b, err = p.MHDR.MarshalBinary()
+	micBytes, err := p.appendMICPayload(make([]byte, 0, 64))
 	if err != nil {
 		return nil, err
 	}
-	micBytes = append(micBytes, b...)
 
-	b, err = macPayload.MarshalBinary()
+	var b0, b1 [16]byte
+	p.setMICBlocks(macPayload, o, len(micBytes), b0[:], b1[:])
+
+	if o.macVersion < LoRaWAN1_1 || !p.isUplink() {
+		return calculateCMAC(provider, key, b0[:], micBytes)
+	}
+
+	sNwkSIntKey := o.sNwkSIntKey
+	if sNwkSIntKey == nil {
+		return nil, errors.New("lorawan: SNwkSIntKey must be set for the LoRaWAN 1.1 uplink MIC")
+	}
+
+	cmacF, err := calculateCMAC(provider, key, b0[:], micBytes)
 	if err != nil {
 		return nil, err
 	}
-	micBytes = append(micBytes, b...)
+	cmacS, err := calculateCMAC(provider, sNwkSIntKey, b1[:], micBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(cmacS[0:2], cmacF[0:2]...), nil
+}
+
+// setMICBlocks sets the B0 block and, for LoRaWAN 1.1 uplink frames, the B1
+// block used for calculating the data-frame MIC over msgLen bytes
+// (MHDR | FHDR | FPort | FRMPayload).
+func (p PHYPayload) setMICBlocks(macPayload *MACPayload, o micOptions, msgLen int, b0, b1 []byte) {
+	var confFCnt uint16
+	if macPayload.FHDR.FCtrl.ACK {
+		confFCnt = uint16(o.confFCnt)
+	}
 
-	b0 := make([]byte, 16)
 	b0[0] = 0x49
+	for i := 1; i < 5; i++ {
+		b0[i] = 0
+	}
+	if o.macVersion >= LoRaWAN1_1 && !p.isUplink() {
+		binary.LittleEndian.PutUint16(b0[1:3], confFCnt)
+	}
+	b0[5] = 0
 	if !p.isUplink() {
 		b0[5] = 1
 	}
-	b, err = macPayload.FHDR.DevAddr.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
-	copy(b0[6:10], b)
+
+	// little endian
+	devAddr := macPayload.FHDR.DevAddr
+	b0[6], b0[7], b0[8], b0[9] = devAddr[3], devAddr[2], devAddr[1], devAddr[0]
 	binary.LittleEndian.PutUint32(b0[10:14], macPayload.FHDR.FCnt)
-	b0[15] = byte(len(micBytes))
+	b0[14] = 0
+	b0[15] = byte(msgLen)
 
-	hash, err := cmac.New(key[:])
-	if err != nil {
-		return nil, err
+	if o.macVersion >= LoRaWAN1_1 && p.isUplink() {
+		copy(b1, b0)
+		binary.LittleEndian.PutUint16(b1[1:3], confFCnt)
+		b1[3] = o.txDR
+		b1[4] = o.txCh
 	}
+}
 
-	if _, err = hash.Write(b0); err != nil {
-		return nil, err
-	}
-	if _, err = hash.Write(micBytes); err != nil {
+// calculateCMAC returns the first 4 bytes of the AES-CMAC of the given data.
+func calculateCMAC(provider KeyProvider, key KeyHandle, data ...[]byte) ([]byte, error) {
+	hb, err := provider.CMAC(key, data...)
+	if err != nil {
 		return nil, err
 	}
-
-	hb := hash.Sum([]byte{})
 	if len(hb) < 4 {
 		return nil, errors.New("lorawan: the hash returned less than 4 bytes")
 	}
@@ -164,45 +379,29 @@ func (p PHYPayload) calculateMIC(key AES128Key) ([]byte, error) {
 }
 
 // calculateJoinRequestMIC calculates and returns the join-request MIC.
-func (p PHYPayload) calculateJoinRequestMIC(key AES128Key) ([]byte, error) {
+func (p PHYPayload) calculateJoinRequestMIC(provider KeyProvider, key KeyHandle) ([]byte, error) {
 	if p.MACPayload == nil {
 		return []byte{}, errors.New("lorawan: MACPayload should not be empty")
 	}
-	jrPayload, ok := p.MACPayload.(*JoinRequestPayload)
-	if !ok {
+	if _, ok := p.MACPayload.(*JoinRequestPayload); !ok {
 		return []byte{}, errors.New("lorawan: MACPayload should be of type *JoinRequestPayload")
 	}
 
-	micBytes := make([]byte, 0, 19)
-
-	b, err := p.MHDR.MarshalBinary()
-	if err != nil {
-		return []byte{}, err
-	}
-	micBytes = append(micBytes, b...)
-
-	b, err = jrPayload.MarshalBinary()
+	micBytes, err := p.appendMICPayload(make([]byte, 0, 19))
 	if err != nil {
 		return nil, err
 	}
-	micBytes = append(micBytes, b...)
 
-	hash, err := cmac.New(key[:])
-	if err != nil {
-		return []byte{}, err
-	}
-	if _, err = hash.Write(micBytes); err != nil {
-		return nil, err
-	}
-	hb := hash.Sum([]byte{})
-	if len(hb) < 4 {
-		return []byte{}, errors.New("lorawan: the hash returned less than 4 bytes")
-	}
-	return hb[0:4], nil
+	return calculateCMAC(provider, key, micBytes)
 }
 
 // calculateJoinAcceptMIC calculates and returns the join-accept MIC.
-func (p PHYPayload) calculateJoinAcceptMIC(key AES128Key) ([]byte, error) {
+// When OptNeg is set and the MAC version is LoRaWAN 1.1, the MIC is
+// calculated over JoinReqType | JoinEUI | DevNonce | MHDR | payload using
+// the JSIntKey, else over MHDR | payload using the AppKey (LoRaWAN 1.0) or
+// NwkKey. For LoRaWAN 1.0.x, bit 7 of the DLSettings is RFU and thus does
+// not change the MIC calculation.
+func (p PHYPayload) calculateJoinAcceptMIC(provider KeyProvider, key KeyHandle, o micOptions) ([]byte, error) {
 	if p.MACPayload == nil {
 		return []byte{}, errors.New("lorawan: MACPayload should not be empty")
 	}
@@ -211,46 +410,81 @@ func (p PHYPayload) calculateJoinAcceptMIC(key AES128Key) ([]byte, error) {
 		return []byte{}, errors.New("lorawan: MACPayload should be of type *JoinAcceptPayload")
 	}
 
-	micBytes := make([]byte, 0, 13)
+	micBytes := make([]byte, 0, 40)
 
-	b, err := p.MHDR.MarshalBinary()
-	if err != nil {
-		return []byte{}, err
+	if jaPayload.DLSettings.OptNeg && o.macVersion >= LoRaWAN1_1 {
+		if o.joinReqType == nil {
+			return nil, errors.New("lorawan: JoinReqType must be set for the LoRaWAN 1.1 join-accept MIC")
+		}
+
+		micBytes = append(micBytes, byte(*o.joinReqType))
+		b, err := o.joinEUI.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		micBytes = append(micBytes, b...)
+		// little endian
+		micBytes = append(micBytes, o.devNonce[1], o.devNonce[0])
 	}
-	micBytes = append(micBytes, b...)
 
-	b, err = jaPayload.MarshalBinary()
+	micBytes, err := p.appendMICPayload(micBytes)
 	if err != nil {
 		return nil, err
 	}
-	micBytes = append(micBytes, b...)
 
-	hash, err := cmac.New(key[:])
-	if err != nil {
-		return []byte{}, err
+	return calculateCMAC(provider, key, micBytes)
+}
+
+// calculateRejoinRequestMIC calculates and returns the rejoin-request MIC.
+// For rejoin-request type 0 and 2, the key is the SNwkSIntKey, for type 1
+// it is the JSIntKey.
+func (p PHYPayload) calculateRejoinRequestMIC(provider KeyProvider, key KeyHandle) ([]byte, error) {
+	if p.MACPayload == nil {
+		return []byte{}, errors.New("lorawan: MACPayload should not be empty")
 	}
-	if _, err = hash.Write(micBytes); err != nil {
-		return nil, err
+
+	switch p.MACPayload.(type) {
+	case *RejoinRequestType02Payload, *RejoinRequestType1Payload:
+	default:
+		return []byte{}, errors.New("lorawan: MACPayload should be of type *RejoinRequestType02Payload or *RejoinRequestType1Payload")
 	}
-	hb := hash.Sum([]byte{})
-	if len(hb) < 4 {
-		return []byte{}, errors.New("lorawan: the hash returned less than 4 bytes")
+
+	micBytes, err := p.appendMICPayload(make([]byte, 0, 20))
+	if err != nil {
+		return nil, err
 	}
-	return hb[0:4], nil
+
+	return calculateCMAC(provider, key, micBytes)
+}
+
+// SetMIC calculates and sets the MIC field. By default the LoRaWAN 1.0 MIC
+// is calculated, use the MICOption values to calculate the LoRaWAN 1.1 MIC.
+func (p *PHYPayload) SetMIC(key AES128Key, opts ...MICOption) error {
+	return p.SetMICWithProvider(SoftwareKeyProvider{}, key, opts...)
 }
 
-// SetMIC calculates and sets the MIC field.
-func (p *PHYPayload) SetMIC(key AES128Key) error {
+// SetMICWithProvider calculates and sets the MIC field using the given
+// KeyProvider and key handle.
+func (p *PHYPayload) SetMICWithProvider(provider KeyProvider, key KeyHandle, opts ...MICOption) error {
 	var mic []byte
 	var err error
 
-	switch p.MACPayload.(type) {
-	case *JoinRequestPayload:
-		mic, err = p.calculateJoinRequestMIC(key)
-	case *JoinAcceptPayload:
-		mic, err = p.calculateJoinAcceptMIC(key)
-	default:
-		mic, err = p.calculateMIC(key)
+	// the MIC is set for the (possibly modified) fields
+	p.raw = nil
+
+	if p.MHDR.MType == Proprietary {
+		mic, err = p.calculateProprietaryMIC(provider, key)
+	} else {
+		switch p.MACPayload.(type) {
+		case *JoinRequestPayload:
+			mic, err = p.calculateJoinRequestMIC(provider, key)
+		case *JoinAcceptPayload:
+			mic, err = p.calculateJoinAcceptMIC(provider, key, p.getMICOptions(opts))
+		case *RejoinRequestType02Payload, *RejoinRequestType1Payload:
+			mic, err = p.calculateRejoinRequestMIC(provider, key)
+		default:
+			mic, err = p.calculateMIC(provider, key, p.getMICOptions(opts))
+		}
 	}
 
 	if err != nil {
@@ -271,17 +505,32 @@ func (p *PHYPayload) SetMIC(key AES128Key) error {
 // the FCnt to the full 32 bit value (based on the observation of the traffic).
 // See section '4.3.1.5 Frame counter (FCnt)' of the LoRaWAN 1.0 specification
 // for more details.
-func (p PHYPayload) ValidateMIC(key AES128Key) (bool, error) {
+// The same options as for SetMIC can be used for validating the LoRaWAN 1.1
+// MIC. When the PHYPayload was decoded from binary form, the MIC is
+// validated over the received bytes (see Remarshal).
+func (p PHYPayload) ValidateMIC(key AES128Key, opts ...MICOption) (bool, error) {
+	return p.ValidateMICWithProvider(SoftwareKeyProvider{}, key, opts...)
+}
+
+// ValidateMICWithProvider returns if the MIC is valid, using the given
+// KeyProvider and key handle.
+func (p PHYPayload) ValidateMICWithProvider(provider KeyProvider, key KeyHandle, opts ...MICOption) (bool, error) {
 	var mic []byte
 	var err error
 
-	switch p.MACPayload.(type) {
-	case *JoinRequestPayload:
-		mic, err = p.calculateJoinRequestMIC(key)
-	case *JoinAcceptPayload:
-		mic, err = p.calculateJoinAcceptMIC(key)
-	default:
-		mic, err = p.calculateMIC(key)
+	if p.MHDR.MType == Proprietary {
+		mic, err = p.calculateProprietaryMIC(provider, key)
+	} else {
+		switch p.MACPayload.(type) {
+		case *JoinRequestPayload:
+			mic, err = p.calculateJoinRequestMIC(provider, key)
+		case *JoinAcceptPayload:
+			mic, err = p.calculateJoinAcceptMIC(provider, key, p.getMICOptions(opts))
+		case *RejoinRequestType02Payload, *RejoinRequestType1Payload:
+			mic, err = p.calculateRejoinRequestMIC(provider, key)
+		default:
+			mic, err = p.calculateMIC(provider, key, p.getMICOptions(opts))
+		}
 	}
 
 	if err != nil {
@@ -298,10 +547,98 @@ func (p PHYPayload) ValidateMIC(key AES128Key) (bool, error) {
 	return true, nil
 }
 
+// Frame-counter validation errors, returned by ValidateMICFCnt.
+var (
+	ErrFCntReplay = errors.New("lorawan: frame-counter replay")
+	ErrFCntGap    = errors.New("lorawan: frame-counter gap exceeds MaxFCntGap")
+)
+
+// ValidateMICFCnt reconstructs the full 32 bit frame-counter from the 16 bit
+// FCnt that is sent over the air and validates the MIC against each
+// candidate frame-counter. expectedFCnt is the next expected frame-counter
+// (e.g. the FCntUp of the last received uplink + 1). The candidates are the
+// frame-counter with the 16 most-significant bits of expectedFCnt, the one
+// after a rollover of the 16 least-significant bits and the one before
+// this rollover (previous epoch).
+// maxFCntGap is the MaxFCntGap of the band (see band.MaxFCntGap), use 0
+// to disable the gap check (LoRaWAN 1.1).
+//
+// When a candidate matches, the full frame-counter is returned and
+// FHDR.FCnt is set to this value. ErrFCntReplay is returned when the
+// matching frame-counter is lower than expectedFCnt, ErrFCntGap when it
+// exceeds expectedFCnt by more than maxFCntGap. The returned bool is false
+// when none of the candidates matches.
+func (p *PHYPayload) ValidateMICFCnt(key AES128Key, expectedFCnt, maxFCntGap uint32, opts ...MICOption) (uint32, bool, error) {
+	return p.ValidateMICFCntWithProvider(SoftwareKeyProvider{}, key, expectedFCnt, maxFCntGap, opts...)
+}
+
+// ValidateMICFCntWithProvider is like ValidateMICFCnt, but validates the
+// MIC using the given KeyProvider and key handle.
+func (p *PHYPayload) ValidateMICFCntWithProvider(provider KeyProvider, key KeyHandle, expectedFCnt, maxFCntGap uint32, opts ...MICOption) (uint32, bool, error) {
+	return p.validateMICFCnt(func() (bool, error) {
+		return p.ValidateMICWithProvider(provider, key, opts...)
+	}, expectedFCnt, maxFCntGap)
+}
+
+// validateMICFCnt implements the frame-counter reconstruction of
+// ValidateMICFCnt, validate validates the MIC with the current FHDR.FCnt.
+func (p *PHYPayload) validateMICFCnt(validate func() (bool, error), expectedFCnt, maxFCntGap uint32) (uint32, bool, error) {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return 0, false, errors.New("lorawan: MACPayload must be of type *MACPayload")
+	}
+
+	origFCnt := macPL.FHDR.FCnt
+	epoch := expectedFCnt & 0xffff0000
+
+	var candidates [3]uint32
+	candidates[0] = epoch | (origFCnt & 0xffff)
+	n := 1
+	if candidates[0] < expectedFCnt && candidates[0]+0x10000 > candidates[0] {
+		candidates[n] = candidates[0] + 0x10000
+		n++
+	}
+	if epoch != 0 {
+		candidates[n] = (epoch - 0x10000) | (origFCnt & 0xffff)
+		n++
+	}
+
+	for _, fCnt := range candidates[:n] {
+		macPL.FHDR.FCnt = fCnt
+		ok, err := validate()
+		if err != nil {
+			macPL.FHDR.FCnt = origFCnt
+			return 0, false, err
+		}
+		if !ok {
+			continue
+		}
+
+		if fCnt < expectedFCnt {
+			return fCnt, true, ErrFCntReplay
+		}
+		if maxFCntGap != 0 && fCnt-expectedFCnt > maxFCntGap {
+			return fCnt, true, ErrFCntGap
+		}
+		return fCnt, true, nil
+	}
+
+	macPL.FHDR.FCnt = origFCnt
+	return 0, false, nil
+}
+
 // EncryptJoinAcceptPayload encrypts the join-accept payload with the given
 // AppKey. Note that encrypted must be performed after calling SetMIC
 // (sicne the MIC is part of the encrypted payload).
+// For LoRaWAN 1.1, the key is the NwkKey when answering a join-request and
+// the JSEncKey when answering a rejoin-request.
 func (p *PHYPayload) EncryptJoinAcceptPayload(appKey AES128Key) error {
+	return p.EncryptJoinAcceptPayloadWithProvider(SoftwareKeyProvider{}, appKey)
+}
+
+// EncryptJoinAcceptPayloadWithProvider encrypts the join-accept payload
+// using the given KeyProvider and key handle.
+func (p *PHYPayload) EncryptJoinAcceptPayloadWithProvider(provider KeyProvider, key KeyHandle) error {
 	if _, ok := p.MACPayload.(*JoinAcceptPayload); !ok {
 		return errors.New("lorawan: MACPayload value must be of type *JoinAcceptPayload")
 	}
@@ -319,26 +656,30 @@ func (p *PHYPayload) EncryptJoinAcceptPayload(appKey AES128Key) error {
 		return errors.New("lorawan: plaintext must be a multiple of 16 bytes")
 	}
 
-	block, err := aes.NewCipher(appKey[:])
+	ct, err := provider.EncryptJoinAccept(key, pt)
 	if err != nil {
 		return err
 	}
-	if block.BlockSize() != 16 {
-		return errors.New("lorawan: block-size of 16 bytes is expected")
-	}
-	ct := make([]byte, len(pt))
-	for i := 0; i < len(ct)/16; i++ {
-		offset := i * 16
-		block.Decrypt(ct[offset:offset+16], pt[offset:offset+16])
+	if len(ct) != len(pt) {
+		return errors.New("lorawan: the encrypted join-accept must have the same length as the plaintext")
 	}
 	p.MACPayload = &DataPayload{Bytes: ct[0 : len(ct)-4]}
 	copy(p.MIC[:], ct[len(ct)-4:])
+	p.raw = nil
 	return nil
 }
 
 // DecryptJoinAcceptPayload decrypts the join-accept payload with the given
 // AppKey. Note that you need to decrypte before you can validate the MIC.
+// For LoRaWAN 1.1, the key is the NwkKey when answering a join-request and
+// the JSEncKey when answering a rejoin-request.
 func (p *PHYPayload) DecryptJoinAcceptPayload(appKey AES128Key) error {
+	return p.DecryptJoinAcceptPayloadWithProvider(SoftwareKeyProvider{}, appKey)
+}
+
+// DecryptJoinAcceptPayloadWithProvider decrypts the join-accept payload
+// using the given KeyProvider and key handle.
+func (p *PHYPayload) DecryptJoinAcceptPayloadWithProvider(provider KeyProvider, key KeyHandle) error {
 	dp, ok := p.MACPayload.(*DataPayload)
 	if !ok {
 		return errors.New("lorawan: MACPayload must be of type *DataPayload")
@@ -351,26 +692,42 @@ func (p *PHYPayload) DecryptJoinAcceptPayload(appKey AES128Key) error {
 		return errors.New("lorawan: plaintext must be a multiple of 16 bytes")
 	}
 
-	block, err := aes.NewCipher(appKey[:])
+	pt, err := provider.DecryptJoinAccept(key, ct)
 	if err != nil {
 		return err
 	}
-	if block.BlockSize() != 16 {
-		return errors.New("lorawan: block-size of 16 bytes is expected")
-	}
-	pt := make([]byte, len(ct))
-	for i := 0; i < len(pt)/16; i++ {
-		offset := i * 16
-		block.Encrypt(pt[offset:offset+16], ct[offset:offset+16])
+	if len(pt) != len(ct) {
+		return errors.New("lorawan: the decrypted join-accept must have the same length as the ciphertext")
 	}
 
-	p.MACPayload = &JoinAcceptPayload{}
+	jaPL := &JoinAcceptPayload{}
+	p.MACPayload = jaPL
 	copy(p.MIC[:], pt[len(pt)-4:len(pt)]) // set the decrypted MIC
-	return p.MACPayload.UnmarshalBinary(p.isUplink(), pt[0:len(pt)-4])
+
+	// the MIC is calculated over the decrypted bytes
+	p.raw = append(p.MHDR.appendBinary(make([]byte, 0, 1+len(pt))), pt...)
+
+	if err := jaPL.UnmarshalBinary(p.isUplink(), pt[0:len(pt)-4]); err != nil {
+		return err
+	}
+
+	// the OptNeg bit is RFU in LoRaWAN 1.0.x
+	if c := p.getCodec(); c.macVersion != nil && *c.macVersion < LoRaWAN1_1 && jaPL.DLSettings.OptNeg {
+		jaPL.DLSettings.OptNeg = false
+		jaPL.DLSettings.RFU = 0x80
+	}
+
+	return nil
 }
 
 // EncryptFRMPayload encrypts the FRMPayload with the given key.
 func (p *PHYPayload) EncryptFRMPayload(key AES128Key) error {
+	return p.EncryptFRMPayloadWithProvider(SoftwareKeyProvider{}, key)
+}
+
+// EncryptFRMPayloadWithProvider encrypts the FRMPayload using the given
+// KeyProvider and key handle.
+func (p *PHYPayload) EncryptFRMPayloadWithProvider(provider KeyProvider, key KeyHandle) error {
 	macPL, ok := p.MACPayload.(*MACPayload)
 	if !ok {
 		return errors.New("lorawan: MACPayload must be of type *MACPayload")
@@ -386,7 +743,7 @@ func (p *PHYPayload) EncryptFRMPayload(key AES128Key) error {
 		return err
 	}
 
-	data, err = EncryptFRMPayload(key, p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, data)
+	data, err = EncryptFRMPayloadWithProvider(provider, key, p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, data)
 	if err != nil {
 		return err
 	}
@@ -399,7 +756,13 @@ func (p *PHYPayload) EncryptFRMPayload(key AES128Key) error {
 
 // DecryptFRMPayload decrypts the FRMPayload with the given key.
 func (p *PHYPayload) DecryptFRMPayload(key AES128Key) error {
-	if err := p.EncryptFRMPayload(key); err != nil {
+	return p.DecryptFRMPayloadWithProvider(SoftwareKeyProvider{}, key)
+}
+
+// DecryptFRMPayloadWithProvider decrypts the FRMPayload using the given
+// KeyProvider and key handle.
+func (p *PHYPayload) DecryptFRMPayloadWithProvider(provider KeyProvider, key KeyHandle) error {
+	if err := p.EncryptFRMPayloadWithProvider(provider, key); err != nil {
 		return err
 	}
 
@@ -415,44 +778,137 @@ func (p *PHYPayload) DecryptFRMPayload(key AES128Key) error {
 			return errors.New("lorawan: a DataPayload was expected")
 		}
 
-		return macPL.unmarshalPayload(p.isUplink(), dp.Bytes)
+		if err := macPL.unmarshalPayload(p.getCodec(), p.isUplink(), dp.Bytes); err != nil {
+			return wrapDecodeError("PHYPayload.MACPayload", 1+7+int(macPL.FHDR.FCtrl.fOptsLen)+1, err)
+		}
 	}
 
 	return nil
 }
 
-// MarshalBinary marshals the object in binary form.
-func (p PHYPayload) MarshalBinary() ([]byte, error) {
-	if p.MACPayload == nil {
-		return []byte{}, errors.New("lorawan: MACPayload should not be nil")
+// EncryptFOpts encrypts the FOpts with the given NwkSEncKey (LoRaWAN 1.1).
+// The encrypted FOpts are stored in FHDR.RawFOpts.
+func (p *PHYPayload) EncryptFOpts(nwkSEncKey AES128Key) error {
+	return p.EncryptFOptsWithProvider(SoftwareKeyProvider{}, nwkSEncKey)
+}
+
+// EncryptFOptsWithProvider encrypts the FOpts using the given KeyProvider
+// and NwkSEncKey handle.
+func (p *PHYPayload) EncryptFOptsWithProvider(provider KeyProvider, nwkSEncKey KeyHandle) error {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
 	}
 
-	var out []byte
-	var b []byte
-	var err error
+	// nothing to encrypt
+	if len(macPL.FHDR.FOpts) == 0 {
+		return nil
+	}
 
-	if b, err = p.MHDR.MarshalBinary(); err != nil {
-		return []byte{}, err
+	data, err := macPL.FHDR.marshalFOpts()
+	if err != nil {
+		return err
+	}
+
+	data, err = EncryptFOptsWithProvider(provider, nwkSEncKey, p.isAFCntDown(), p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, data)
+	if err != nil {
+		return err
+	}
+
+	macPL.FHDR.FOpts = nil
+	macPL.FHDR.RawFOpts = data
+
+	return nil
+}
+
+// DecryptFOpts decrypts the FHDR.RawFOpts with the given NwkSEncKey
+// (LoRaWAN 1.1) and decodes them into FHDR.FOpts.
+func (p *PHYPayload) DecryptFOpts(nwkSEncKey AES128Key) error {
+	return p.DecryptFOptsWithProvider(SoftwareKeyProvider{}, nwkSEncKey)
+}
+
+// DecryptFOptsWithProvider decrypts the FHDR.RawFOpts using the given
+// KeyProvider and NwkSEncKey handle.
+func (p *PHYPayload) DecryptFOptsWithProvider(provider KeyProvider, nwkSEncKey KeyHandle) error {
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return errors.New("lorawan: MACPayload must be of type *MACPayload")
 	}
-	out = append(out, b...)
 
-	if b, err = p.MACPayload.MarshalBinary(); err != nil {
+	// nothing to decrypt
+	if len(macPL.FHDR.RawFOpts) == 0 {
+		return nil
+	}
+
+	data := make([]byte, len(macPL.FHDR.RawFOpts))
+	copy(data, macPL.FHDR.RawFOpts)
+
+	data, err := EncryptFOptsWithProvider(provider, nwkSEncKey, p.isAFCntDown(), p.isUplink(), macPL.FHDR.DevAddr, macPL.FHDR.FCnt, data)
+	if err != nil {
+		return err
+	}
+
+	macPL.FHDR.FOpts = nil
+	if err := macPL.FHDR.unmarshalFOpts(p.getCodec(), p.isUplink(), data); err != nil {
+		return wrapDecodeError("PHYPayload.MACPayload.FHDR", 1+7, err)
+	}
+	macPL.FHDR.RawFOpts = nil
+
+	return nil
+}
+
+// MarshalBinary marshals the object in binary form.
+func (p PHYPayload) MarshalBinary() ([]byte, error) {
+	out, err := p.AppendBinary(nil)
+	if err != nil {
 		return []byte{}, err
 	}
-	out = append(out, b...)
-	out = append(out, p.MIC[0:len(p.MIC)]...)
 	return out, nil
 }
 
-// UnmarshalBinary decodes the object from binary form.
+// AppendBinary appends the object in binary form to out. This makes it
+// possible to re-use a buffer for encoding frames. Unless MAC command
+// payloads must be marshaled, this does not allocate when out has enough
+// capacity (e.g. 256 bytes).
+func (p PHYPayload) AppendBinary(out []byte) ([]byte, error) {
+	if p.MACPayload == nil {
+		return out, errors.New("lorawan: MACPayload should not be nil")
+	}
+
+	start := len(out)
+	out, err := appendPayload(p.MHDR.appendBinary(out), p.MACPayload)
+	if err != nil {
+		return out[:start], err
+	}
+	return append(out, p.MIC[:]...), nil
+}
+
+// UnmarshalBinary decodes the object from binary form. On error, a
+// *DecodeError is returned.
 func (p *PHYPayload) UnmarshalBinary(data []byte) error {
+	return p.unmarshalBinary(DefaultCodec, false, data)
+}
+
+// UnmarshalBinaryRawFOpts decodes the object from binary form, but unlike
+// UnmarshalBinary it does not decode the FOpts into MAC commands. Instead
+// the FOpts bytes are stored in FHDR.RawFOpts. Use this for LoRaWAN 1.1
+// frames, of which the FOpts are encrypted, and call DecryptFOpts to
+// decrypt and decode them.
+func (p *PHYPayload) UnmarshalBinaryRawFOpts(data []byte) error {
+	return p.unmarshalBinary(DefaultCodec, true, data)
+}
+
+func (p *PHYPayload) unmarshalBinary(codec *Codec, rawFOpts bool, data []byte) error {
 	if len(data) < 5 {
-		return errors.New("lorawan: at least 5 bytes needed to decode PHYPayload")
+		return wrapDecodeError("PHYPayload", 0, newError(ErrTooShort, "lorawan: at least 5 bytes needed to decode PHYPayload"))
 	}
 
 	// MHDR
 	if err := p.MHDR.UnmarshalBinary(data[0:1]); err != nil {
-		return err
+		return wrapDecodeError("PHYPayload.MHDR", 0, err)
+	}
+	if err := codec.validateMHDR(p.MHDR); err != nil {
+		return wrapDecodeError("PHYPayload.MHDR", 0, err)
 	}
 
 	// MACPayload
@@ -461,19 +917,57 @@ func (p *PHYPayload) UnmarshalBinary(data []byte) error {
 		p.MACPayload = &JoinRequestPayload{}
 	case JoinAccept:
 		p.MACPayload = &DataPayload{}
+	case RejoinRequest:
+		switch RejoinType(data[1]) {
+		case RejoinRequestType1:
+			p.MACPayload = &RejoinRequestType1Payload{}
+		default:
+			p.MACPayload = &RejoinRequestType02Payload{}
+		}
+	case Proprietary:
+		p.MACPayload = &DataPayload{}
 	default:
 		p.MACPayload = &MACPayload{}
 	}
 
 	isUplink := p.isUplink()
-	if err := p.MACPayload.UnmarshalBinary(isUplink, data[1:len(data)-4]); err != nil {
-		return err
+
+	if p.MHDR.MType == Proprietary {
+		if h, ok := codec.getProprietaryHandler(p.MHDR.Major); ok {
+			if h.Payload != nil {
+				p.MACPayload = h.Payload()
+			}
+			if h.IsUplink != nil {
+				isUplink = h.IsUplink(data)
+			}
+		}
+	}
+	switch pl := p.MACPayload.(type) {
+	case *MACPayload:
+		if err := pl.unmarshalBinary(codec, isUplink, rawFOpts, data[1:len(data)-4]); err != nil {
+			return wrapDecodeError("PHYPayload.MACPayload", 1, err)
+		}
+	case *DataPayload:
+		p.MACPayload = codec.unmarshalDataPayload(data[1 : len(data)-4])
+	default:
+		if err := p.MACPayload.UnmarshalBinary(isUplink, data[1:len(data)-4]); err != nil {
+			return wrapDecodeError("PHYPayload.MACPayload", 1, err)
+		}
 	}
 
 	// MIC
 	for i := 0; i < 4; i++ {
 		p.MIC[i] = data[len(data)-4+i]
 	}
+
+	if codec.aliasDataPayload {
+		p.raw = data[:len(data):len(data)]
+	} else {
+		p.raw = make([]byte, len(data))
+		copy(p.raw, data)
+	}
+	p.codec = codec
+
 	return nil
 }
 
@@ -497,57 +991,87 @@ func (p *PHYPayload) UnmarshalText(text []byte) error {
 
 // isUplink returns a bool indicating if the packet is uplink or downlink.
 // Note that for MType Proprietary it can't derrive if the packet is uplink
-// or downlink. This is fine (I think) since it is also unknown how to
-// calculate the MIC and the format of the MACPayload. A pluggable
-// MIC calculation and MACPayload for Proprietary MType is still TODO.
+// or downlink. When decoding a proprietary frame, the IsUplink function of
+// the registered ProprietaryHandler is used instead.
 func (p PHYPayload) isUplink() bool {
 	switch p.MHDR.MType {
-	case JoinRequest, UnconfirmedDataUp, ConfirmedDataUp:
+	case JoinRequest, UnconfirmedDataUp, ConfirmedDataUp, RejoinRequest:
 		return true
 	default:
 		return false
 	}
 }
 
+// isAFCntDown returns a bool indicating if the frame is a downlink using the
+// AFCntDown frame-counter (LoRaWAN 1.1). This is the case for downlinks
+// with FPort > 0, downlinks without FPort or with FPort = 0 use the
+// NFCntDown.
+func (p PHYPayload) isAFCntDown() bool {
+	if p.isUplink() {
+		return false
+	}
+	macPL, ok := p.MACPayload.(*MACPayload)
+	if !ok {
+		return false
+	}
+	return macPL.FPort != nil && *macPL.FPort > 0
+}
+
 // EncryptFRMPayload encrypts the FRMPayload (slice of bytes).
 // Note that EncryptFRMPayload is used for both encryption and decryption.
 func EncryptFRMPayload(key AES128Key, uplink bool, devAddr DevAddr, fCnt uint32, data []byte) ([]byte, error) {
-	pLen := len(data)
-	if pLen%16 != 0 {
-		// append with empty bytes so that len(data) is a multiple of 16
-		data = append(data, make([]byte, 16-(pLen%16))...)
+	return EncryptFRMPayloadWithProvider(SoftwareKeyProvider{}, key, uplink, devAddr, fCnt, data)
+}
+
+// EncryptFRMPayloadWithProvider encrypts the FRMPayload (slice of bytes)
+// using the given KeyProvider and key handle.
+func EncryptFRMPayloadWithProvider(provider KeyProvider, key KeyHandle, uplink bool, devAddr DevAddr, fCnt uint32, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
 	}
 
-	block, err := aes.NewCipher(key[:])
+	s, err := provider.FRMPayloadKeyStream(key, uplink, devAddr, fCnt, len(data))
 	if err != nil {
 		return nil, err
 	}
-	if block.BlockSize() != 16 {
-		return nil, errors.New("lorawan: block size of 16 was expected")
+	if len(s) < len(data) {
+		return nil, newError(ErrInvalidLength, "lorawan: the key stream is shorter than the data")
+	}
+
+	for i := range data {
+		data[i] = data[i] ^ s[i]
 	}
 
-	s := make([]byte, 16)
-	a := make([]byte, 16)
-	a[0] = 0x01
-	if !uplink {
-		a[5] = 0x01
+	return data, nil
+}
+
+// EncryptFOpts encrypts the FOpts (slice of bytes) with the NwkSEncKey
+// (LoRaWAN 1.1). For uplink frames, fCnt is the FCntUp, for downlink frames
+// it is the AFCntDown when aFCntDown is set or the NFCntDown otherwise.
+// Note that EncryptFOpts is used for both encryption and decryption.
+// The block A is constructed as defined by the LoRaWAN 1.1 errata:
+// 0x01 | 3 x 0x00 | 0x01 (NFCntDown / FCntUp) or 0x02 (AFCntDown) | Dir | DevAddr | FCnt | 0x00 | 0x01
+func EncryptFOpts(nwkSEncKey AES128Key, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32, data []byte) ([]byte, error) {
+	return EncryptFOptsWithProvider(SoftwareKeyProvider{}, nwkSEncKey, aFCntDown, uplink, devAddr, fCnt, data)
+}
+
+// EncryptFOptsWithProvider encrypts the FOpts (slice of bytes) using the
+// given KeyProvider and NwkSEncKey handle.
+func EncryptFOptsWithProvider(provider KeyProvider, nwkSEncKey KeyHandle, aFCntDown, uplink bool, devAddr DevAddr, fCnt uint32, data []byte) ([]byte, error) {
+	if len(data) > 15 {
+		return nil, errors.New("lorawan: max size of FOpts is 15 bytes")
 	}
 
-	b, err := devAddr.MarshalBinary()
+	s, err := provider.FOptsKeyStream(nwkSEncKey, aFCntDown, uplink, devAddr, fCnt)
 	if err != nil {
 		return nil, err
 	}
-	copy(a[6:10], b)
-	binary.LittleEndian.PutUint32(a[10:14], uint32(fCnt))
-
-	for i := 0; i < len(data)/16; i++ {
-		a[15] = byte(i + 1)
-		block.Encrypt(s, a)
-
-		for j := 0; j < len(s); j++ {
-			data[i*16+j] = data[i*16+j] ^ s[j]
-		}
+	if len(s) < len(data) {
+		return nil, newError(ErrInvalidLength, "lorawan: the key stream is shorter than the data")
+	}
+	for i := range data {
+		data[i] = data[i] ^ s[i]
 	}
 
-	return data[0:pLen], nil
+	return data, nil
 }
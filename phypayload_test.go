@@ -3,6 +3,7 @@ package lorawan
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -69,6 +70,15 @@ func TestMHDR(t *testing.T) {
 				So(h, ShouldResemble, MHDR{MType: Proprietary, Major: LoRaWANR1})
 			})
 		})
+
+		Convey("Given a slice []byte{192}", func() {
+			b := []byte{192}
+			Convey("Then UnmarshalBinary returns a MHDR with MType=RejoinRequest (formerly RFU)", func() {
+				So(h.UnmarshalBinary(b), ShouldBeNil)
+				So(h.MType, ShouldEqual, RejoinRequest)
+				So(h.MType, ShouldEqual, RFU)
+			})
+		})
 	})
 }
 
@@ -184,6 +194,453 @@ func TestPHYPayloadUplinkMACEncryption(t *testing.T) {
 	})
 }
 
+func TestPHYPayloadLoRaWAN11MIC(t *testing.T) {
+	Convey("Given FNwkSIntKey=0102030405060708090a0b0c0d0e0f10 and SNwkSIntKey=100f0e0d0c0b0a090807060504030201", t, func() {
+		fNwkSIntKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		sNwkSIntKey := AES128Key{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+		fPort := uint8(1)
+
+		Convey("Given an uplink PHYPayload with ACK=true, FCnt=8, FPort=1 and FRMPayload=[]byte{1, 2, 3, 4}", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: UnconfirmedDataUp,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{
+						DevAddr: DevAddr{1, 2, 3, 4},
+						FCtrl:   FCtrl{ACK: true},
+						FCnt:    8,
+					},
+					FPort:      &fPort,
+					FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3, 4}}},
+				},
+			}
+			opts := []MICOption{
+				WithMACVersion(LoRaWAN1_1),
+				WithSNwkSIntKey(sNwkSIntKey),
+				WithConfFCnt(5),
+				WithTxDR(2),
+				WithTxCh(3),
+			}
+
+			Convey("Then SetMIC with LoRaWAN1_1 sets the MIC to [4]byte{222, 34, 55, 170}", func() {
+				So(phy.SetMIC(fNwkSIntKey, opts...), ShouldBeNil)
+				So(phy.MIC, ShouldEqual, [4]byte{222, 34, 55, 170})
+
+				Convey("Then ValidateMIC with the same options returns true", func() {
+					ok, err := phy.ValidateMIC(fNwkSIntKey, opts...)
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeTrue)
+				})
+
+				Convey("Then ValidateMIC with a different TxCh returns false", func() {
+					ok, err := phy.ValidateMIC(fNwkSIntKey, append(opts, WithTxCh(4))...)
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeFalse)
+				})
+			})
+
+			Convey("Then SetMIC with LoRaWAN1_1 without SNwkSIntKey returns an error", func() {
+				So(phy.SetMIC(fNwkSIntKey, WithMACVersion(LoRaWAN1_1)), ShouldResemble, errors.New("lorawan: SNwkSIntKey must be set for the LoRaWAN 1.1 uplink MIC"))
+			})
+		})
+
+		Convey("Given a downlink PHYPayload with ACK=true, FCnt=8, FPort=1 and FRMPayload=[]byte{1, 2, 3, 4}", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: UnconfirmedDataDown,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{
+						DevAddr: DevAddr{1, 2, 3, 4},
+						FCtrl:   FCtrl{ACK: true},
+						FCnt:    8,
+					},
+					FPort:      &fPort,
+					FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3, 4}}},
+				},
+			}
+
+			Convey("Then SetMIC with LoRaWAN1_1 and ConfFCnt=5 sets the MIC to [4]byte{222, 111, 16, 70}", func() {
+				So(phy.SetMIC(sNwkSIntKey, WithMACVersion(LoRaWAN1_1), WithConfFCnt(5)), ShouldBeNil)
+				So(phy.MIC, ShouldEqual, [4]byte{222, 111, 16, 70})
+			})
+
+			Convey("Then SetMIC with LoRaWAN1_0 ignores the ConfFCnt and sets the MIC to [4]byte{221, 140, 45, 211}", func() {
+				So(phy.SetMIC(sNwkSIntKey, WithConfFCnt(5)), ShouldBeNil)
+				So(phy.MIC, ShouldEqual, [4]byte{221, 140, 45, 211})
+			})
+		})
+	})
+}
+
+func TestPHYPayloadRawBytes(t *testing.T) {
+	Convey("Given an uplink frame with RFU bits set in the MHDR and a MIC calculated over the received bytes", t, func() {
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+		// MHDR (RFU bits set) | DevAddr | FCtrl | FCnt | FPort | FRMPayload
+		b := []byte{0x5c, 4, 3, 2, 1, 0, 1, 0, 1, 1, 2, 3}
+		b0 := []byte{0x49, 0, 0, 0, 0, 0, 4, 3, 2, 1, 1, 0, 0, 0, 0, byte(len(b))}
+		mic, err := SoftwareKeyProvider{}.CMAC(key, b0, b)
+		So(err, ShouldBeNil)
+		b = append(b, mic[0:4]...)
+
+		var phy PHYPayload
+		So(phy.UnmarshalBinary(b), ShouldBeNil)
+
+		Convey("Then ValidateMIC returns true", func() {
+			ok, err := phy.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Then ValidateMIC returns true after decrypting the FRMPayload", func() {
+			So(phy.DecryptFRMPayload(AES128Key{}), ShouldBeNil)
+			ok, err := phy.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Then after Remarshal, ValidateMIC returns true as the RFU bits are preserved", func() {
+			So(phy.MHDR.RFU, ShouldEqual, 0x1c)
+			So(phy.Remarshal(), ShouldBeNil)
+			ok, err := phy.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Then after SetMIC, the MIC is calculated over the fields", func() {
+			phy.MACPayload.(*MACPayload).FHDR.FCnt = 2
+			So(phy.SetMIC(key), ShouldBeNil)
+			ok, err := phy.ValidateMIC(key)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			out, err := phy.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(out[0], ShouldEqual, 0x5c)
+			So(out[6], ShouldEqual, 2)
+		})
+	})
+}
+
+func TestPHYPayloadAppendBinary(t *testing.T) {
+	Convey("Given a set of PHYPayloads", t, func() {
+		fPort0 := uint8(0)
+
+		testTable := []struct {
+			Name       string
+			PHYPayload PHYPayload
+		}{
+			{
+				Name:       "uplink with RawFOpts and FRMPayload",
+				PHYPayload: newTestDataUp(),
+			},
+			{
+				Name: "uplink with FOpts",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: UnconfirmedDataUp, Major: LoRaWANR1},
+					MACPayload: &MACPayload{
+						FHDR: FHDR{
+							DevAddr: DevAddr{1, 2, 3, 4},
+							FOpts: []MACCommand{
+								{CID: LinkCheckReq},
+								{CID: DevStatusAns, Payload: &DevStatusAnsPayload{Battery: 100, Margin: -5}},
+							},
+						},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "downlink with MAC commands in the FRMPayload",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: UnconfirmedDataDown, Major: LoRaWANR1},
+					MACPayload: &MACPayload{
+						FHDR:       FHDR{DevAddr: DevAddr{1, 2, 3, 4}},
+						FPort:      &fPort0,
+						FRMPayload: []Payload{&MACCommand{CID: DevStatusReq}, &MACCommand{CID: RXTimingSetupReq, Payload: &RXTimingSetupReqPayload{Delay: 2}}},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "join-request",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: JoinRequest, Major: LoRaWANR1},
+					MACPayload: &JoinRequestPayload{
+						AppEUI:   EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+						DevEUI:   EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+						DevNonce: [2]byte{1, 2},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "join-accept",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: JoinAccept, Major: LoRaWANR1},
+					MACPayload: &JoinAcceptPayload{
+						AppNonce:   [3]byte{1, 2, 3},
+						NetID:      [3]byte{3, 2, 1},
+						DevAddr:    DevAddr{1, 2, 3, 4},
+						DLSettings: DLsettings{RX2DataRate: 2, RX1DRoffset: 1},
+						RXDelay:    1,
+						CFList:     &CFList{867100000, 867300000},
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "rejoin-request type 0",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: RejoinRequest, Major: LoRaWANR1},
+					MACPayload: &RejoinRequestType02Payload{
+						RejoinType: RejoinRequestType0,
+						NetID:      [3]byte{1, 2, 3},
+						DevEUI:     EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+						RJCount0:   1234,
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+			{
+				Name: "rejoin-request type 1",
+				PHYPayload: PHYPayload{
+					MHDR: MHDR{MType: RejoinRequest, Major: LoRaWANR1},
+					MACPayload: &RejoinRequestType1Payload{
+						RejoinType: RejoinRequestType1,
+						JoinEUI:    EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+						DevEUI:     EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+						RJCount1:   1234,
+					},
+					MIC: [4]byte{1, 2, 3, 4},
+				},
+			},
+		}
+
+		for _, test := range testTable {
+			Convey("Testing: "+test.Name, func() {
+				expected, err := test.PHYPayload.MarshalBinary()
+				So(err, ShouldBeNil)
+
+				Convey("Then AppendBinary appends the same bytes as returned by MarshalBinary", func() {
+					b, err := test.PHYPayload.AppendBinary([]byte{0xff, 0xfe})
+					So(err, ShouldBeNil)
+					So(b, ShouldResemble, append([]byte{0xff, 0xfe}, expected...))
+				})
+			})
+		}
+	})
+
+	Convey("Given a PHYPayload which can not be marshaled", t, func() {
+		phy := PHYPayload{
+			MHDR: MHDR{MType: RejoinRequest, Major: LoRaWANR1},
+			MACPayload: &RejoinRequestType1Payload{
+				RejoinType: RejoinRequestType0,
+			},
+		}
+
+		Convey("Then AppendBinary returns an error and the unmodified slice", func() {
+			b, err := phy.AppendBinary([]byte{1, 2, 3})
+			So(err, ShouldResemble, errors.New("lorawan: RejoinType must be 1"))
+			So(b, ShouldResemble, []byte{1, 2, 3})
+		})
+	})
+
+	Convey("Given an uplink PHYPayload and a buffer with enough capacity", t, func() {
+		phy := newTestDataUp()
+		phy.MACPayload.(*MACPayload).FHDR.RawFOpts = nil
+		phy.MACPayload.(*MACPayload).FHDR.FOpts = []MACCommand{{CID: LinkCheckReq}}
+		buf := make([]byte, 0, 256)
+
+		Convey("Then AppendBinary does not allocate", func() {
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := phy.AppendBinary(buf[:0]); err != nil {
+					panic(err)
+				}
+			})
+			So(allocs, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestPHYPayloadValidateMICFCnt(t *testing.T) {
+	Convey("Given an uplink with FCnt=65541 (0x00010005) and a valid MIC", t, func() {
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		fPort := uint8(1)
+		phy := PHYPayload{
+			MHDR: MHDR{
+				MType: UnconfirmedDataUp,
+				Major: LoRaWANR1,
+			},
+			MACPayload: &MACPayload{
+				FHDR: FHDR{
+					DevAddr: DevAddr{1, 2, 3, 4},
+					FCnt:    0x00010005,
+				},
+				FPort:      &fPort,
+				FRMPayload: []Payload{&DataPayload{Bytes: []byte{1, 2, 3}}},
+			},
+		}
+		So(phy.SetMIC(key), ShouldBeNil)
+
+		b, err := phy.MarshalBinary()
+		So(err, ShouldBeNil)
+
+		Convey("Given the received frame, containing the 16 LSB of the FCnt", func() {
+			var rx PHYPayload
+			So(rx.UnmarshalBinary(b), ShouldBeNil)
+			macPL := rx.MACPayload.(*MACPayload)
+			So(macPL.FHDR.FCnt, ShouldEqual, 5)
+
+			tests := []struct {
+				Name         string
+				Key          AES128Key
+				ExpectedFCnt uint32
+				MaxFCntGap   uint32
+				FCnt         uint32
+				Valid        bool
+				Error        error
+			}{
+				{"expected FCnt=65539", key, 0x00010003, 16384, 0x00010005, true, nil},
+				{"expected FCnt=65541", key, 0x00010005, 16384, 0x00010005, true, nil},
+				{"expected FCnt=65520 (rollover of the 16 LSB)", key, 0x0000fff0, 16384, 0x00010005, true, nil},
+				{"expected FCnt=65542 (replay)", key, 0x00010006, 16384, 0x00010005, true, ErrFCntReplay},
+				{"expected FCnt=49152 (gap)", key, 0x0000c000, 16384, 0x00010005, true, ErrFCntGap},
+				{"expected FCnt=49157 (gap of exactly MaxFCntGap)", key, 0x0000c005, 16384, 0x00010005, true, nil},
+				{"expected FCnt=49156 (gap of MaxFCntGap + 1)", key, 0x0000c004, 16384, 0x00010005, true, ErrFCntGap},
+				{"expected FCnt=49152 and the gap check disabled", key, 0x0000c000, 0, 0x00010005, true, nil},
+				{"expected FCnt=131075 (replay of the previous epoch)", key, 0x00020003, 16384, 0x00010005, true, ErrFCntReplay},
+				{"an invalid key", AES128Key{}, 0x00010003, 16384, 5, false, nil},
+			}
+
+			for _, test := range tests {
+				Convey(fmt.Sprintf("Given %s", test.Name), func() {
+					Convey(fmt.Sprintf("Then ValidateMICFCnt returns FCnt=%d, valid=%v and error=%v", test.FCnt, test.Valid, test.Error), func() {
+						fCnt, valid, err := rx.ValidateMICFCnt(test.Key, test.ExpectedFCnt, test.MaxFCntGap)
+						So(err, ShouldEqual, test.Error)
+						So(valid, ShouldEqual, test.Valid)
+						if test.Valid {
+							So(fCnt, ShouldEqual, test.FCnt)
+						}
+						So(macPL.FHDR.FCnt, ShouldEqual, test.FCnt)
+					})
+
+					Convey("Then ValidateMICFCntWithProvider returns the same", func() {
+						fCnt, valid, err := rx.ValidateMICFCntWithProvider(SoftwareKeyProvider{}, test.Key, test.ExpectedFCnt, test.MaxFCntGap)
+						So(err, ShouldEqual, test.Error)
+						So(valid, ShouldEqual, test.Valid)
+						if test.Valid {
+							So(fCnt, ShouldEqual, test.FCnt)
+						}
+						So(macPL.FHDR.FCnt, ShouldEqual, test.FCnt)
+					})
+
+					Convey("Then ValidateMICFCntWithContext returns the same", func() {
+						ctx, err := NewCipherContext(test.Key)
+						So(err, ShouldBeNil)
+						fCnt, valid, err := rx.ValidateMICFCntWithContext(ctx, test.ExpectedFCnt, test.MaxFCntGap)
+						So(err, ShouldEqual, test.Error)
+						So(valid, ShouldEqual, test.Valid)
+						if test.Valid {
+							So(fCnt, ShouldEqual, test.FCnt)
+						}
+						So(macPL.FHDR.FCnt, ShouldEqual, test.FCnt)
+					})
+				})
+			}
+		})
+	})
+}
+
+func TestPHYPayloadFOptsEncryption(t *testing.T) {
+	Convey("Given NwkSEncKey=0102030405060708090a0b0c0d0e0f10", t, func() {
+		nwkSEncKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+		Convey("Given an uplink PHYPayload with FCnt=8 and FOpts=[LinkCheckReq, DevStatusAns(Battery=10, Margin=20)]", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: UnconfirmedDataUp,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{
+						DevAddr: DevAddr{1, 2, 3, 4},
+						FCnt:    8,
+						FOpts: []MACCommand{
+							{CID: LinkCheckReq},
+							{CID: DevStatusAns, Payload: &DevStatusAnsPayload{Battery: 10, Margin: 20}},
+						},
+					},
+				},
+			}
+
+			Convey("Then EncryptFOpts stores the encrypted FOpts in RawFOpts", func() {
+				So(phy.EncryptFOpts(nwkSEncKey), ShouldBeNil)
+				macPL := phy.MACPayload.(*MACPayload)
+				So(macPL.FHDR.FOpts, ShouldHaveLength, 0)
+				So(macPL.FHDR.RawFOpts, ShouldResemble, []byte{130, 152, 246, 117})
+
+				Convey("When marshaling and unmarshaling the PHYPayload with UnmarshalBinaryRawFOpts", func() {
+					b, err := phy.MarshalBinary()
+					So(err, ShouldBeNil)
+
+					var out PHYPayload
+					So(out.UnmarshalBinaryRawFOpts(b), ShouldBeNil)
+
+					Convey("Then DecryptFOpts decodes the original MAC commands", func() {
+						So(out.DecryptFOpts(nwkSEncKey), ShouldBeNil)
+						outPL := out.MACPayload.(*MACPayload)
+						So(outPL.FHDR.RawFOpts, ShouldBeNil)
+						So(outPL.FHDR.FOpts, ShouldResemble, []MACCommand{
+							{CID: LinkCheckReq},
+							{CID: DevStatusAns, Payload: &DevStatusAnsPayload{Battery: 10, Margin: 20}},
+						})
+					})
+				})
+			})
+		})
+
+		Convey("Given a downlink PHYPayload with FCnt=8 and FOpts=[LinkCheckAns(Margin=10, GwCnt=15)]", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: UnconfirmedDataDown,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &MACPayload{
+					FHDR: FHDR{
+						DevAddr: DevAddr{1, 2, 3, 4},
+						FCnt:    8,
+						FOpts: []MACCommand{
+							{CID: LinkCheckAns, Payload: &LinkCheckAnsPayload{Margin: 10, GwCnt: 15}},
+						},
+					},
+				},
+			}
+
+			Convey("Given no FPort (NFCntDown)", func() {
+				Convey("Then EncryptFOpts sets RawFOpts to []byte{69, 238, 137}", func() {
+					So(phy.EncryptFOpts(nwkSEncKey), ShouldBeNil)
+					So(phy.MACPayload.(*MACPayload).FHDR.RawFOpts, ShouldResemble, []byte{69, 238, 137})
+				})
+			})
+
+			Convey("Given FPort=1 (AFCntDown)", func() {
+				fPort := uint8(1)
+				phy.MACPayload.(*MACPayload).FPort = &fPort
+
+				Convey("Then EncryptFOpts sets RawFOpts to []byte{24, 227, 192}", func() {
+					So(phy.EncryptFOpts(nwkSEncKey), ShouldBeNil)
+					So(phy.MACPayload.(*MACPayload).FHDR.RawFOpts, ShouldResemble, []byte{24, 227, 192})
+				})
+			})
+		})
+	})
+}
+
 func TestPHYPayloadJoinRequest(t *testing.T) {
 	Convey("Given a set of known and an empty PHYPayload", t, func() {
 		data, err := base64.StdEncoding.DecodeString("AAQDAgEEAwIBBQQDAgUEAwItEGqZDhI=")
@@ -229,6 +686,73 @@ func TestPHYPayloadJoinRequest(t *testing.T) {
 	})
 }
 
+func TestPHYPayloadRejoinRequest(t *testing.T) {
+	Convey("Given the key 0102030405060708090a0b0c0d0e0f10", t, func() {
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+		Convey("Given a rejoin-request type 0 c00003020108070605040302010201b4fd9bde", func() {
+			b, err := hex.DecodeString("c00003020108070605040302010201b4fd9bde")
+			So(err, ShouldBeNil)
+
+			var phy PHYPayload
+			Convey("Then UnmarshalBinary returns a RejoinRequestType02Payload", func() {
+				So(phy.UnmarshalBinary(b), ShouldBeNil)
+				So(phy.MHDR.MType, ShouldEqual, RejoinRequest)
+				So(phy.MACPayload, ShouldResemble, &RejoinRequestType02Payload{
+					RejoinType: RejoinRequestType0,
+					NetID:      NetID{1, 2, 3},
+					DevEUI:     EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+					RJCount0:   258,
+				})
+
+				Convey("Then the MIC is valid", func() {
+					ok, err := phy.ValidateMIC(key)
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeTrue)
+				})
+
+				Convey("Then MarshalBinary returns the input data", func() {
+					out, err := phy.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(out, ShouldResemble, b)
+				})
+			})
+		})
+
+		Convey("Given a rejoin-request type 1 with JoinEUI=0102030405060708, DevEUI=0203040506070809 and RJCount1=3", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: RejoinRequest,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &RejoinRequestType1Payload{
+					RejoinType: RejoinRequestType1,
+					JoinEUI:    EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+					DevEUI:     EUI64{2, 3, 4, 5, 6, 7, 8, 9},
+					RJCount1:   3,
+				},
+			}
+
+			Convey("Then SetMIC sets the MIC to [4]byte{97, 119, 150, 120}", func() {
+				So(phy.SetMIC(key), ShouldBeNil)
+				So(phy.MIC, ShouldEqual, [4]byte{97, 119, 150, 120})
+
+				Convey("Then marshaling and unmarshaling returns the same PHYPayload", func() {
+					b, err := phy.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(hex.EncodeToString(b), ShouldEqual, "c00108070605040302010908070605040302030061779678")
+
+					var out PHYPayload
+					So(out.UnmarshalBinary(b), ShouldBeNil)
+					So(out.MHDR, ShouldResemble, phy.MHDR)
+					So(out.MACPayload, ShouldResemble, phy.MACPayload)
+					So(out.MIC, ShouldEqual, phy.MIC)
+				})
+			})
+		})
+	})
+}
+
 func TestPHYPayloadJoinAccept(t *testing.T) {
 	Convey("Given an empty PHYPayload with empty JoinAcceptPayload", t, func() {
 		p := PHYPayload{MACPayload: &JoinAcceptPayload{}}
@@ -330,6 +854,65 @@ func TestPHYPayloadJoinAccept(t *testing.T) {
 	})
 }
 
+func TestPHYPayloadLoRaWAN11JoinAccept(t *testing.T) {
+	Convey("Given JSIntKey=0102030405060708090a0b0c0d0e0f10 and NwkKey=100f0e0d0c0b0a090807060504030201", t, func() {
+		jsIntKey := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		nwkKey := AES128Key{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+		joinEUI := EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+		devNonce := [2]byte{1, 2}
+
+		Convey("Given a join-accept with OptNeg=true, answering a join-request", func() {
+			phy := PHYPayload{
+				MHDR: MHDR{
+					MType: JoinAccept,
+					Major: LoRaWANR1,
+				},
+				MACPayload: &JoinAcceptPayload{
+					AppNonce:   [3]byte{1, 2, 3},
+					NetID:      [3]byte{4, 5, 6},
+					DevAddr:    DevAddr{1, 2, 3, 4},
+					DLSettings: DLsettings{OptNeg: true},
+					RXDelay:    1,
+				},
+			}
+
+			Convey("Then SetMIC without JoinReqType returns an error", func() {
+				So(phy.SetMIC(jsIntKey, WithMACVersion(LoRaWAN1_1)), ShouldResemble, errors.New("lorawan: JoinReqType must be set for the LoRaWAN 1.1 join-accept MIC"))
+			})
+
+			Convey("Then SetMIC sets the MIC to [4]byte{134, 42, 100, 24}", func() {
+				So(phy.SetMIC(jsIntKey, WithMACVersion(LoRaWAN1_1), WithJoinReqType(JoinRequestType, joinEUI, devNonce)), ShouldBeNil)
+				So(phy.MIC, ShouldEqual, [4]byte{134, 42, 100, 24})
+
+				Convey("Then encrypting with the NwkKey returns 2061ade5715a560293529eeb5cfd6aa3a7", func() {
+					So(phy.EncryptJoinAcceptPayload(nwkKey), ShouldBeNil)
+					b, err := phy.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(hex.EncodeToString(b), ShouldEqual, "2061ade5715a560293529eeb5cfd6aa3a7")
+
+					Convey("Then decrypting and validating the MIC succeeds", func() {
+						var out PHYPayload
+						So(out.UnmarshalBinary(b), ShouldBeNil)
+						So(out.DecryptJoinAcceptPayload(nwkKey), ShouldBeNil)
+
+						jaPL, ok := out.MACPayload.(*JoinAcceptPayload)
+						So(ok, ShouldBeTrue)
+						So(jaPL.DLSettings.OptNeg, ShouldBeTrue)
+
+						ok, err := out.ValidateMIC(jsIntKey, WithMACVersion(LoRaWAN1_1), WithJoinReqType(JoinRequestType, joinEUI, devNonce))
+						So(err, ShouldBeNil)
+						So(ok, ShouldBeTrue)
+
+						ok, err = out.ValidateMIC(jsIntKey, WithMACVersion(LoRaWAN1_1), WithJoinReqType(JoinType(RejoinRequestType0), joinEUI, devNonce))
+						So(err, ShouldBeNil)
+						So(ok, ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
 func ExamplePHYPayload() {
 	nwkSKey := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
 	appSKey := [16]byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
@@ -484,3 +1067,59 @@ func ExamplePHYPayload_readJoinRequest() {
 	// 0203040502030405
 	// [16 45]
 }
+
+func BenchmarkPHYPayloadMarshalBinary(b *testing.B) {
+	phy := newTestDataUp()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := phy.MarshalBinary(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPHYPayloadAppendBinary(b *testing.B) {
+	phy := newTestDataUp()
+	buf := make([]byte, 0, 256)
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := phy.AppendBinary(buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPHYPayloadUnmarshalBinary(b *testing.B) {
+	data, err := newTestDataUp().MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	codec := NewCodec(WithRawFOpts())
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		var phy PHYPayload
+		if err := codec.UnmarshalPHYPayload(&phy, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPHYPayloadUnmarshalBinaryAliased(b *testing.B) {
+	data, err := newTestDataUp().MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	codec := NewCodec(WithRawFOpts(), WithAliasedDataPayload())
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		var phy PHYPayload
+		if err := codec.UnmarshalPHYPayload(&phy, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
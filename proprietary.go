@@ -0,0 +1,100 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ProprietaryHandler implements the decoding and MIC calculation of
+// proprietary frames (MType Proprietary). All functions are optional.
+type ProprietaryHandler struct {
+	// IsUplink returns if the given frame (MHDR | MACPayload | MIC) is an
+	// uplink frame. When not set, proprietary frames are decoded as
+	// downlink.
+	IsUplink func(data []byte) bool
+
+	// Payload returns a new Payload for decoding the MACPayload of the
+	// frame. When not set, the MACPayload is decoded as DataPayload.
+	Payload func() Payload
+
+	// MIC returns the MIC of the frame, data contains MHDR | MACPayload.
+	// When not set, SetMIC and ValidateMIC return an error.
+	MIC func(provider KeyProvider, key KeyHandle, data []byte) ([4]byte, error)
+}
+
+// WithProprietaryHandler registers the handler for proprietary frames with
+// the given major version with the codec, replacing an existing handler.
+func WithProprietaryHandler(major Major, h ProprietaryHandler) CodecOption {
+	return func(c *Codec) {
+		c.proprietaryHandlers[major] = h
+	}
+}
+
+// RegisterProprietaryHandler registers the handler for proprietary frames
+// with the given major version with the codec. An error is returned when a
+// handler is already registered for this major version.
+func (c *Codec) RegisterProprietaryHandler(major Major, h ProprietaryHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.proprietaryHandlers[major]; ok {
+		return fmt.Errorf("lorawan: proprietary handler already registered for major %d", major)
+	}
+	c.proprietaryHandlers[major] = h
+	return nil
+}
+
+// UnregisterProprietaryHandler removes the handler for proprietary frames
+// with the given major version from the codec.
+func (c *Codec) UnregisterProprietaryHandler(major Major) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.proprietaryHandlers, major)
+}
+
+// getProprietaryHandler returns the handler for the given major version.
+func (c *Codec) getProprietaryHandler(major Major) (ProprietaryHandler, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	h, ok := c.proprietaryHandlers[major]
+	return h, ok
+}
+
+// RegisterProprietaryHandler registers the handler for proprietary frames
+// with the given major version with the DefaultCodec. An error is returned
+// when a handler is already registered for this major version.
+func RegisterProprietaryHandler(major Major, h ProprietaryHandler) error {
+	return DefaultCodec.RegisterProprietaryHandler(major, h)
+}
+
+// UnregisterProprietaryHandler removes the handler for proprietary frames
+// with the given major version from the DefaultCodec.
+func UnregisterProprietaryHandler(major Major) {
+	DefaultCodec.UnregisterProprietaryHandler(major)
+}
+
+// calculateProprietaryMIC calculates the MIC of a proprietary frame, using
+// the ProprietaryHandler registered with the codec of the PHYPayload.
+func (p PHYPayload) calculateProprietaryMIC(provider KeyProvider, key KeyHandle) ([]byte, error) {
+	if p.MACPayload == nil {
+		return []byte{}, errors.New("lorawan: MACPayload should not be empty")
+	}
+
+	h, ok := p.getCodec().getProprietaryHandler(p.MHDR.Major)
+	if !ok || h.MIC == nil {
+		return nil, fmt.Errorf("lorawan: no MIC function registered for proprietary frames with major %d", p.MHDR.Major)
+	}
+
+	micBytes, err := p.appendMICPayload(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mic, err := h.MIC(provider, key, micBytes)
+	if err != nil {
+		return nil, err
+	}
+	return mic[:], nil
+}
@@ -0,0 +1,149 @@
+package lorawan
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testProprietaryPayload is a proprietary payload of which the first byte
+// contains the direction (0x80 = uplink) and the channel.
+type testProprietaryPayload struct {
+	Uplink  bool
+	Channel uint8
+	Data    []byte
+}
+
+func (p testProprietaryPayload) MarshalBinary() ([]byte, error) {
+	b := p.Channel & 0x7f
+	if p.Uplink {
+		b |= 0x80
+	}
+	return append([]byte{b}, p.Data...), nil
+}
+
+func (p *testProprietaryPayload) UnmarshalBinary(uplink bool, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("at least 1 byte expected")
+	}
+	p.Uplink = uplink
+	p.Channel = data[0] & 0x7f
+	p.Data = make([]byte, len(data)-1)
+	copy(p.Data, data[1:])
+	return nil
+}
+
+func TestProprietaryHandler(t *testing.T) {
+	Convey("Given a proprietary uplink frame", t, func() {
+		key := AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		phy := PHYPayload{
+			MHDR: MHDR{
+				MType: Proprietary,
+				Major: LoRaWANR1,
+			},
+			MACPayload: &testProprietaryPayload{
+				Uplink:  true,
+				Channel: 3,
+				Data:    []byte{1, 2, 3},
+			},
+		}
+
+		Convey("Then SetMIC returns an error when no handler is registered", func() {
+			So(phy.SetMIC(key), ShouldResemble, errors.New("lorawan: no MIC function registered for proprietary frames with major 0"))
+		})
+
+		Convey("Then UnmarshalBinary decodes the MACPayload as DataPayload when no handler is registered", func() {
+			var out PHYPayload
+			So(out.UnmarshalBinary([]byte{0xe0, 0x83, 1, 2, 3, 4, 5, 6, 7}), ShouldBeNil)
+			So(out.MACPayload, ShouldResemble, &DataPayload{Bytes: []byte{0x83, 1, 2, 3}})
+			So(out.MIC, ShouldEqual, [4]byte{4, 5, 6, 7})
+		})
+
+		Convey("Given a registered ProprietaryHandler", func() {
+			So(RegisterProprietaryHandler(LoRaWANR1, ProprietaryHandler{
+				IsUplink: func(data []byte) bool {
+					return data[1]&0x80 != 0
+				},
+				Payload: func() Payload {
+					return &testProprietaryPayload{}
+				},
+				MIC: func(provider KeyProvider, key KeyHandle, data []byte) ([4]byte, error) {
+					var mic [4]byte
+					b, err := provider.CMAC(key, data)
+					if err != nil {
+						return mic, err
+					}
+					copy(mic[:], b)
+					return mic, nil
+				},
+			}), ShouldBeNil)
+			defer UnregisterProprietaryHandler(LoRaWANR1)
+
+			Convey("Then registering an other handler for the same major returns an error", func() {
+				So(RegisterProprietaryHandler(LoRaWANR1, ProprietaryHandler{}), ShouldResemble, errors.New("lorawan: proprietary handler already registered for major 0"))
+			})
+
+			Convey("Then SetMIC uses the MIC function of the handler", func() {
+				So(phy.SetMIC(key), ShouldBeNil)
+
+				b, err := phy.MarshalBinary()
+				So(err, ShouldBeNil)
+				mic, err := SoftwareKeyProvider{}.CMAC(key, b[:len(b)-4])
+				So(err, ShouldBeNil)
+				So(phy.MIC[:], ShouldResemble, mic[0:4])
+
+				Convey("Then UnmarshalBinary decodes the frame using the handler", func() {
+					var out PHYPayload
+					So(out.UnmarshalBinary(b), ShouldBeNil)
+					So(out.MHDR, ShouldResemble, phy.MHDR)
+					So(out.MACPayload, ShouldResemble, phy.MACPayload)
+
+					ok, err := out.ValidateMIC(key)
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeTrue)
+
+					ok, err = out.ValidateMIC(AES128Key{})
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Given a codec with a ProprietaryHandler", func() {
+			codec := NewCodec(WithProprietaryHandler(LoRaWANR1, ProprietaryHandler{
+				Payload: func() Payload {
+					return &testProprietaryPayload{}
+				},
+				MIC: func(provider KeyProvider, key KeyHandle, data []byte) ([4]byte, error) {
+					return [4]byte{1, 2, 3, 4}, nil
+				},
+			}))
+
+			Convey("Then registering an other handler for the same major returns an error", func() {
+				So(codec.RegisterProprietaryHandler(LoRaWANR1, ProprietaryHandler{}), ShouldResemble, errors.New("lorawan: proprietary handler already registered for major 0"))
+			})
+
+			Convey("Then the codec decodes the frame using the handler and the DefaultCodec does not", func() {
+				b := []byte{0xe0, 0x83, 1, 2, 3, 1, 2, 3, 4}
+
+				var out PHYPayload
+				So(codec.UnmarshalPHYPayload(&out, b), ShouldBeNil)
+				So(out.MACPayload, ShouldResemble, &testProprietaryPayload{Channel: 3, Data: []byte{1, 2, 3}})
+
+				ok, err := out.ValidateMIC(key)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+
+				var outDefault PHYPayload
+				So(outDefault.UnmarshalBinary(b), ShouldBeNil)
+				So(outDefault.MACPayload, ShouldResemble, &DataPayload{Bytes: []byte{0x83, 1, 2, 3}})
+			})
+
+			Convey("Then UnregisterProprietaryHandler removes the handler", func() {
+				codec.UnregisterProprietaryHandler(LoRaWANR1)
+				So(codec.RegisterProprietaryHandler(LoRaWANR1, ProprietaryHandler{}), ShouldBeNil)
+			})
+		})
+	})
+}